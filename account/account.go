@@ -0,0 +1,327 @@
+// Package account provides a SQL account store producing opay.SettleFunc for each asset,
+// with row locking or optimistic version checking, and overdraft protection.
+// It also provides an opay.Freezer for each asset, keeping the frozen balance apart from the available one.
+//
+//	store := account.New(db, "opay_account")
+//	store.CreateTable()
+//	store.SetCreditLimit("2", opay.MustParseAmount("100"))
+//	store.Register(nil, "1", "2")
+package account
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/henrylee2cn/opay"
+	"github.com/jmoiron/sqlx"
+)
+
+type (
+	// Store keeps the balance of each Uid-Aid account in a table.
+	Store struct {
+		db         *sqlx.DB
+		dialect    opay.Dialect
+		table      string
+		optimistic bool                   //check the version column instead of locking the row
+		limits     map[string]opay.Amount //credit limit per asset, the balance can not be less than -limit
+		mu         sync.RWMutex
+	}
+
+	// Account is the balance of a Uid-Aid account.
+	Account struct {
+		Uid       string      `json:"uid" db:"uid"`
+		Aid       string      `json:"aid" db:"aid"`
+		Balance   opay.Amount `json:"balance" db:"balance"` //available balance
+		Frozen    opay.Amount `json:"frozen" db:"frozen"`   //frozen balance
+		Version   int64       `json:"version" db:"version"`
+		UpdatedAt int64       `json:"updated_at" db:"updated_at"`
+	}
+
+	// InsufficientBalanceError is returned if the balance is not enough to debit the amount.
+	InsufficientBalanceError struct {
+		Uid     string
+		Aid     string
+		Balance opay.Amount //the balance before debiting
+		Amount  opay.Amount //the debited amount, negative
+		Limit   opay.Amount //the credit limit
+	}
+
+	// The error which may not occur if retried.
+	transientError struct {
+		msg string
+	}
+)
+
+var (
+	// ErrInsufficientBalance matches every *InsufficientBalanceError with errors.Is.
+	ErrInsufficientBalance = errors.New("account: insufficient balance.")
+	// ErrInsufficientFrozen is returned if the frozen balance is not enough to unfreeze or capture.
+	ErrInsufficientFrozen = errors.New("account: insufficient frozen balance.")
+	// ErrConflict is returned if the optimistic version checking fails,
+	// which is transient, so the request is retried by opay.RetryPolicy.
+	ErrConflict error = &transientError{"account: the balance has been modified concurrently."}
+)
+
+// Error implements error.
+func (e *InsufficientBalanceError) Error() string {
+	return fmt.Sprintf("account: insufficient balance of uid '%s' aid '%s', balance: %s, amount: %s, credit limit: %s.",
+		e.Uid, e.Aid, e.Balance, e.Amount, e.Limit)
+}
+
+// Is reports whether target is ErrInsufficientBalance.
+func (e *InsufficientBalanceError) Is(target error) bool {
+	return target == ErrInsufficientBalance
+}
+
+// Error implements error.
+func (e *transientError) Error() string {
+	return e.msg
+}
+
+// Transient reports that the error may not occur if retried, which is classified by opay.IsTransient.
+func (e *transientError) Transient() bool {
+	return true
+}
+
+// New creates an account store on the table.
+func New(db *sqlx.DB, table string) *Store {
+	return &Store{
+		db:      db,
+		dialect: opay.DialectOf(db.DriverName()),
+		table:   table,
+		limits:  make(map[string]opay.Amount),
+	}
+}
+
+// DDL returns the statements creating the table for the database dialect.
+func (s *Store) DDL() []string {
+	return s.dialect.CreateTable(s.table, []string{
+		"uid VARCHAR(64) NOT NULL",
+		"aid VARCHAR(16) NOT NULL",
+		"balance " + s.dialect.Decimal() + " NOT NULL",
+		"frozen " + s.dialect.Decimal() + " NOT NULL DEFAULT 0",
+		"version BIGINT NOT NULL DEFAULT 0",
+		"updated_at BIGINT NOT NULL",
+		"PRIMARY KEY (uid, aid)",
+	})
+}
+
+// CreateTable creates the table if not exists.
+func (s *Store) CreateTable() error {
+	return s.dialect.Exec(s.db, s.DDL())
+}
+
+// SetOptimistic sets whether to check the version column instead of locking the row,
+// ErrConflict is returned if the account is modified concurrently,
+// set an opay.RetryPolicy to retry the requests.
+func (s *Store) SetOptimistic(optimistic bool) {
+	s.mu.Lock()
+	s.optimistic = optimistic
+	s.mu.Unlock()
+}
+
+// SetCreditLimit allows the balance of the asset to be overdrawn down to -limit.
+func (s *Store) SetCreditLimit(aid string, limit opay.Amount) {
+	s.mu.Lock()
+	s.limits[aid] = limit.Abs()
+	s.mu.Unlock()
+}
+
+// SettleFunc returns the account balance operation function of the asset.
+func (s *Store) SettleFunc(aid string) opay.SettleFunc {
+	return func(uid string, amount opay.Amount, tx *sqlx.Tx) error {
+		return s.Settle(tx, uid, aid, amount)
+	}
+}
+
+// Freezer returns the frozen balance operation interface of the asset.
+func (s *Store) Freezer(aid string) opay.Freezer {
+	return &freezer{store: s, aid: aid}
+}
+
+// Register registers the SettleFunc and Freezer of the assets to m, or to the global map if m is nil.
+func (s *Store) Register(m *opay.SettleFuncMap, aids ...string) error {
+	for _, aid := range aids {
+		var err error
+		if m == nil {
+			err = opay.RegSettleFunc(aid, s.SettleFunc(aid))
+			if err == nil {
+				err = opay.RegFreezer(aid, s.Freezer(aid))
+			}
+		} else {
+			err = m.RegSettleFunc(aid, s.SettleFunc(aid))
+			if err == nil {
+				err = m.RegFreezer(aid, s.Freezer(aid))
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get returns the account, which is zero if not exists.
+func (s *Store) Get(uid, aid string) (*Account, error) {
+	a := &Account{Uid: uid, Aid: aid}
+	err := s.db.Get(a, s.db.Rebind(
+		"SELECT uid, aid, balance, frozen, version, updated_at FROM "+s.table+" WHERE uid = ? AND aid = ?"),
+		uid, aid,
+	)
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	return a, err
+}
+
+// Settle adds amount to the available balance of the Uid-Aid account in the transaction.
+func (s *Store) Settle(tx *sqlx.Tx, uid, aid string, amount opay.Amount) error {
+	return s.modify(tx, uid, aid, func(a *Account, limit opay.Amount) (err error) {
+		a.Balance, err = add(a, amount, limit)
+		return
+	})
+}
+
+// Freeze moves the amount from the available balance to the frozen balance in the transaction.
+func (s *Store) Freeze(tx *sqlx.Tx, uid, aid string, amount opay.Amount) error {
+	return s.modify(tx, uid, aid, func(a *Account, limit opay.Amount) error {
+		return freeze(a, amount, limit)
+	})
+}
+
+// Unfreeze moves the amount from the frozen balance back to the available balance in the transaction.
+func (s *Store) Unfreeze(tx *sqlx.Tx, uid, aid string, amount opay.Amount) error {
+	return s.modify(tx, uid, aid, func(a *Account, _ opay.Amount) error {
+		return unfreeze(a, amount)
+	})
+}
+
+// Capture debits the amount from the frozen balance in the transaction.
+func (s *Store) Capture(tx *sqlx.Tx, uid, aid string, amount opay.Amount) error {
+	return s.modify(tx, uid, aid, func(a *Account, _ opay.Amount) error {
+		return capture(a, amount)
+	})
+}
+
+// Locks the account, or checks the version if optimistic, then saves the modified balances.
+func (s *Store) modify(tx *sqlx.Tx, uid, aid string, fn func(a *Account, limit opay.Amount) error) error {
+	s.mu.RLock()
+	optimistic, limit := s.optimistic, s.limits[aid]
+	s.mu.RUnlock()
+
+	now := time.Now().Unix()
+
+	// Makes sure the row exists, so that it can be locked.
+	_, err := tx.Exec(tx.Rebind(s.dialect.InsertIgnore(
+		"INSERT INTO "+s.table+" (uid, aid, balance, frozen, version, updated_at) VALUES (?, ?, ?, ?, 0, ?)")),
+		uid, aid, opay.Amount{}, opay.Amount{}, now,
+	)
+	if err != nil {
+		return err
+	}
+
+	a := &Account{}
+	query := "SELECT uid, aid, balance, frozen, version, updated_at FROM " + s.table + " WHERE uid = ? AND aid = ?"
+	if !optimistic {
+		query += s.dialect.ForUpdate(false)
+	}
+	err = tx.Get(a, tx.Rebind(query), uid, aid)
+	if err != nil {
+		return err
+	}
+
+	version := a.Version
+	err = fn(a, limit)
+	if err != nil {
+		return err
+	}
+
+	query = "UPDATE " + s.table + " SET balance = ?, frozen = ?, version = version + 1, updated_at = ? WHERE uid = ? AND aid = ?"
+	args := []interface{}{a.Balance, a.Frozen, now, uid, aid}
+	if optimistic {
+		query += " AND version = ?"
+		args = append(args, version)
+	}
+	result, err := tx.Exec(tx.Rebind(query), args...)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err == nil && n == 0 {
+		err = ErrConflict
+	}
+	return err
+}
+
+// Returns the new balance, checks the overdraft if debiting.
+func add(a *Account, amount, limit opay.Amount) (opay.Amount, error) {
+	balance := a.Balance.Add(amount)
+	if amount.Sign() < 0 && balance.Cmp(limit.Neg()) < 0 {
+		return balance, &InsufficientBalanceError{
+			Uid:     a.Uid,
+			Aid:     a.Aid,
+			Balance: a.Balance,
+			Amount:  amount,
+			Limit:   limit,
+		}
+	}
+	return balance, nil
+}
+
+// Moves the amount from the available balance to the frozen balance, checks the overdraft.
+func freeze(a *Account, amount, limit opay.Amount) error {
+	if amount.Sign() <= 0 {
+		return opay.ErrIncorrectAmount
+	}
+	balance, err := add(a, amount.Neg(), limit)
+	if err != nil {
+		return err
+	}
+	a.Balance, a.Frozen = balance, a.Frozen.Add(amount)
+	return nil
+}
+
+// Moves the amount from the frozen balance back to the available balance.
+func unfreeze(a *Account, amount opay.Amount) error {
+	err := capture(a, amount)
+	if err != nil {
+		return err
+	}
+	a.Balance = a.Balance.Add(amount)
+	return nil
+}
+
+// Debits the amount from the frozen balance.
+func capture(a *Account, amount opay.Amount) error {
+	if amount.Sign() <= 0 {
+		return opay.ErrIncorrectAmount
+	}
+	if a.Frozen.Cmp(amount) < 0 {
+		return ErrInsufficientFrozen
+	}
+	a.Frozen = a.Frozen.Sub(amount)
+	return nil
+}
+
+// freezer adapts the Store to opay.Freezer of an asset.
+type freezer struct {
+	store *Store
+	aid   string
+}
+
+var _ opay.Freezer = (*freezer)(nil)
+
+func (f *freezer) Freeze(uid string, amount opay.Amount, tx *sqlx.Tx) error {
+	return f.store.Freeze(tx, uid, f.aid, amount)
+}
+
+func (f *freezer) Unfreeze(uid string, amount opay.Amount, tx *sqlx.Tx) error {
+	return f.store.Unfreeze(tx, uid, f.aid, amount)
+}
+
+func (f *freezer) Capture(uid string, amount opay.Amount, tx *sqlx.Tx) error {
+	return f.store.Capture(tx, uid, f.aid, amount)
+}
@@ -0,0 +1,109 @@
+package account
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/henrylee2cn/opay"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestAdd(t *testing.T) {
+	a := &Account{Uid: "u1", Aid: "1", Balance: opay.MustParseAmount("10.00")}
+
+	balance, err := add(a, opay.MustParseAmount("-10"), opay.Amount{})
+	if err != nil || !balance.IsZero() {
+		t.Fatalf("debit all: %s %v", balance, err)
+	}
+
+	_, err = add(a, opay.MustParseAmount("-10.01"), opay.Amount{})
+	if !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("overdraft: %v", err)
+	}
+	t.Log(err)
+
+	balance, err = add(a, opay.MustParseAmount("-15"), opay.MustParseAmount("5"))
+	if err != nil || balance.String() != "-5.00" {
+		t.Fatalf("credit limit: %s %v", balance, err)
+	}
+
+	// Crediting is always allowed.
+	a.Balance = opay.MustParseAmount("-20")
+	if _, err = add(a, opay.MustParseAmount("1"), opay.Amount{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFreeze(t *testing.T) {
+	a := &Account{Uid: "u1", Aid: "1", Balance: opay.MustParseAmount("10.00")}
+
+	if err := freeze(a, opay.MustParseAmount("10.01"), opay.Amount{}); !errors.Is(err, ErrInsufficientBalance) {
+		t.Fatalf("overdraft: %v", err)
+	}
+	if err := freeze(a, opay.MustParseAmount("8"), opay.Amount{}); err != nil {
+		t.Fatal(err)
+	}
+	if a.Balance.String() != "2.00" || a.Frozen.String() != "8" {
+		t.Fatalf("freeze: %s %s", a.Balance, a.Frozen)
+	}
+
+	// Captures 5 and releases the rest.
+	if err := capture(a, opay.MustParseAmount("5")); err != nil {
+		t.Fatal(err)
+	}
+	if err := unfreeze(a, opay.MustParseAmount("3.01")); err != ErrInsufficientFrozen {
+		t.Fatalf("unfreeze too much: %v", err)
+	}
+	if err := unfreeze(a, opay.MustParseAmount("3")); err != nil {
+		t.Fatal(err)
+	}
+	if a.Balance.String() != "5.00" || !a.Frozen.IsZero() {
+		t.Fatalf("release: %s %s", a.Balance, a.Frozen)
+	}
+}
+
+func TestStoreRoundTrip(t *testing.T) {
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	s := New(db, "account")
+	if err = s.CreateTable(); err != nil {
+		t.Fatal(err)
+	}
+	settle := func(amount string) error {
+		tx, err := db.Beginx()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Rollback()
+		if err = s.Settle(tx, "u1", "1", opay.MustParseAmount(amount)); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+	for _, amount := range []string{"100.00", "-10.50", "900000"} {
+		if err = settle(amount); err != nil {
+			t.Fatalf("settle %s: %v", amount, err)
+		}
+	}
+	a, err := s.Get("u1", "1")
+	if err != nil || !a.Balance.Equal(opay.MustParseAmount("900089.50")) || a.Version != 3 {
+		t.Fatalf("get: %+v %v", a, err)
+	}
+
+	// MySQL and Postgres return the DECIMAL(36,18) columns with all the decimal places.
+	if _, err = db.Exec("UPDATE account SET balance = '900089.500000000000000000' WHERE uid = 'u1'"); err != nil {
+		t.Fatal(err)
+	}
+	if err = settle("100000"); err != nil {
+		t.Fatal(err)
+	}
+	if a, err = s.Get("u1", "1"); err != nil || !a.Balance.Equal(opay.MustParseAmount("1000089.5")) {
+		t.Fatalf("get after decimal: %+v %v", a, err)
+	}
+}
@@ -0,0 +1,375 @@
+package opay
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Amount is an exact fixed-point decimal of money,
+// stored as int64 units with up to MAX_AMOUNT_SCALE decimal places.
+// The zero value is 0.
+// Use Equal or Cmp to compare, since 1.0 and 1.00 have different scales.
+// The arithmetic panics on int64 overflow instead of losing money silently.
+type Amount struct {
+	units int64 //unscaled value
+	scale uint8 //number of decimal places
+}
+
+const (
+	MAX_AMOUNT_SCALE = 18 // MAX_AMOUNT_SCALE is the max number of decimal places of Amount
+)
+
+var (
+	_ sql.Scanner      = (*Amount)(nil)
+	_ driver.Valuer    = Amount{}
+	_ json.Marshaler   = Amount{}
+	_ json.Unmarshaler = (*Amount)(nil)
+	_ fmt.Stringer     = Amount{}
+)
+
+var pow10 [MAX_AMOUNT_SCALE + 1]int64
+
+func init() {
+	pow10[0] = 1
+	for i := 1; i <= MAX_AMOUNT_SCALE; i++ {
+		pow10[i] = pow10[i-1] * 10
+	}
+}
+
+// NewAmount returns units*10^-scale, e.g. NewAmount(1234, 2) is 12.34.
+func NewAmount(units int64, scale int) Amount {
+	checkScale(scale)
+	return Amount{units: units, scale: uint8(scale)}
+}
+
+// ParseAmount parses a decimal string, such as "-12.34" or "1.5e3".
+// The trailing zeros beyond MAX_AMOUNT_SCALE or int64 are dropped,
+// e.g. "100.000000000000000000" is 100.
+func ParseAmount(s string) (Amount, error) {
+	var (
+		str      = s
+		neg      bool
+		exponent int
+	)
+	if i := strings.IndexAny(str, "eE"); i >= 0 {
+		e, err := strconv.Atoi(str[i+1:])
+		if err != nil {
+			return Amount{}, ErrInvalidAmount.With("opay.invalid_amount.detail", s)
+		}
+		exponent, str = e, str[:i]
+	}
+	if len(str) > 0 && (str[0] == '-' || str[0] == '+') {
+		neg, str = str[0] == '-', str[1:]
+	}
+	intPart, fracPart := str, ""
+	if i := strings.IndexByte(str, '.'); i >= 0 {
+		intPart, fracPart = str[:i], str[i+1:]
+	}
+	digits := intPart + fracPart
+	if len(digits) == 0 || strings.Trim(digits, "0123456789") != "" {
+		return Amount{}, ErrInvalidAmount.With("opay.invalid_amount.detail", s)
+	}
+	// Rejects the huge exponents before building the digits.
+	if exponent > MAX_AMOUNT_SCALE+19 {
+		return Amount{}, ErrAmountOverflow.With("opay.amount_overflow.detail", s)
+	}
+	if exponent < -(MAX_AMOUNT_SCALE + 19) {
+		return Amount{}, ErrAmountScale.With("opay.amount_scale.detail", s, MAX_AMOUNT_SCALE)
+	}
+
+	scale := len(fracPart) - exponent
+	digits = strings.TrimLeft(digits, "0")
+	if len(digits) == 0 {
+		// Zero keeps the scale if possible.
+		if scale < 0 {
+			scale = 0
+		} else if scale > MAX_AMOUNT_SCALE {
+			scale = MAX_AMOUNT_SCALE
+		}
+		return Amount{scale: uint8(scale)}, nil
+	}
+	// Drops the trailing zeros beyond the max scale, or not fitting in int64.
+	for scale > 0 && digits[len(digits)-1] == '0' && (scale > MAX_AMOUNT_SCALE || len(digits) > 19) {
+		digits, scale = digits[:len(digits)-1], scale-1
+	}
+	if scale > MAX_AMOUNT_SCALE {
+		return Amount{}, ErrAmountScale.With("opay.amount_scale.detail", s, MAX_AMOUNT_SCALE)
+	}
+	if scale < 0 {
+		digits += strings.Repeat("0", -scale)
+		scale = 0
+	}
+	var sign string
+	if neg {
+		sign = "-"
+	}
+	for len(digits) <= 19 {
+		units, err := strconv.ParseInt(sign+digits, 10, 64)
+		if err == nil {
+			return Amount{units: units, scale: uint8(scale)}, nil
+		}
+		if scale == 0 || digits[len(digits)-1] != '0' {
+			break
+		}
+		digits, scale = digits[:len(digits)-1], scale-1
+	}
+	return Amount{}, ErrAmountOverflow.With("opay.amount_overflow.detail", s)
+}
+
+// MustParseAmount is like ParseAmount but panics if s can not be parsed.
+func MustParseAmount(s string) Amount {
+	a, err := ParseAmount(s)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// AmountFromFloat converts f to Amount, rounded to scale decimal places.
+// ErrIncorrectAmount is returned if f is NaN, infinite or out of range.
+func AmountFromFloat(f float64, scale int) (Amount, error) {
+	checkScale(scale)
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return Amount{}, ErrIncorrectAmount
+	}
+	a, err := ParseAmount(strconv.FormatFloat(f, 'f', scale, 64))
+	if err != nil {
+		return Amount{}, ErrIncorrectAmount.Wrap(err)
+	}
+	return a, nil
+}
+
+// MustAmountFromFloat is like AmountFromFloat but panics if f can not be converted.
+func MustAmountFromFloat(f float64, scale int) Amount {
+	a, err := AmountFromFloat(f, scale)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// Units returns the unscaled value.
+func (a Amount) Units() int64 {
+	return a.units
+}
+
+// Scale returns the number of decimal places.
+func (a Amount) Scale() int {
+	return int(a.scale)
+}
+
+// Float64 returns the nearest float64 value, only for display or legacy code.
+func (a Amount) Float64() float64 {
+	f, _ := strconv.ParseFloat(a.String(), 64)
+	return f
+}
+
+// String returns the decimal string with all the decimal places, e.g. "-12.30".
+func (a Amount) String() string {
+	s := strconv.FormatInt(a.units, 10)
+	if a.scale == 0 {
+		return s
+	}
+	var sign string
+	if a.units < 0 {
+		sign, s = "-", s[1:]
+	}
+	if n := int(a.scale) + 1 - len(s); n > 0 {
+		s = strings.Repeat("0", n) + s
+	}
+	return sign + s[:len(s)-int(a.scale)] + "." + s[len(s)-int(a.scale):]
+}
+
+// Sign returns -1, 0 or +1.
+func (a Amount) Sign() int {
+	switch {
+	case a.units < 0:
+		return -1
+	case a.units > 0:
+		return 1
+	}
+	return 0
+}
+
+// IsZero reports whether a is 0.
+func (a Amount) IsZero() bool {
+	return a.units == 0
+}
+
+// Neg returns -a.
+func (a Amount) Neg() Amount {
+	if a.units == -1<<63 {
+		panic(ErrAmountOverflow)
+	}
+	return Amount{units: -a.units, scale: a.scale}
+}
+
+// Abs returns |a|.
+func (a Amount) Abs() Amount {
+	if a.units < 0 {
+		return a.Neg()
+	}
+	return a
+}
+
+// Cmp returns -1 if a < b, 0 if a == b, +1 if a > b.
+func (a Amount) Cmp(b Amount) int {
+	return a.big(b.scale).Cmp(b.big(a.scale))
+}
+
+// Equal reports whether a == b, regardless of the scales.
+func (a Amount) Equal(b Amount) bool {
+	return a.Cmp(b) == 0
+}
+
+// Add returns a+b, with the larger scale.
+func (a Amount) Add(b Amount) Amount {
+	sum, err := a.add(b)
+	if err != nil {
+		panic(err)
+	}
+	return sum
+}
+
+// Returns a+b, or ErrAmountOverflow instead of panicking.
+func (a Amount) add(b Amount) (Amount, error) {
+	scale := maxScale(a, b)
+	return checkedFromBig(new(big.Int).Add(a.big(scale), b.big(scale)), scale)
+}
+
+// Sub returns a-b, with the larger scale.
+func (a Amount) Sub(b Amount) Amount {
+	scale := maxScale(a, b)
+	return fromBig(new(big.Int).Sub(a.big(scale), b.big(scale)), scale)
+}
+
+// Mul returns a*b, rounded half away from zero to scale decimal places.
+func (a Amount) Mul(b Amount, scale int) Amount {
+	return fromRat(new(big.Rat).Mul(a.rat(), b.rat()), scale)
+}
+
+// Div returns a/b, rounded half away from zero to scale decimal places.
+// It panics if b is 0.
+func (a Amount) Div(b Amount, scale int) Amount {
+	if b.IsZero() {
+		panic("opay: division of amount by zero.")
+	}
+	return fromRat(new(big.Rat).Quo(a.rat(), b.rat()), scale)
+}
+
+// Round returns a rounded half away from zero to scale decimal places.
+func (a Amount) Round(scale int) Amount {
+	return fromRat(a.rat(), scale)
+}
+
+// MarshalJSON implements the json.Marshaler interface, as a JSON number.
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface,
+// both JSON number and string are accepted.
+func (a *Amount) UnmarshalJSON(b []byte) error {
+	s := string(b)
+	if s == "null" {
+		return nil
+	}
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		s = s[1 : len(s)-1]
+	}
+	v, err := ParseAmount(s)
+	if err != nil {
+		return err
+	}
+	*a = v
+	return nil
+}
+
+// Scan implements the sql Scanner interface.
+func (a *Amount) Scan(value interface{}) (err error) {
+	switch v := value.(type) {
+	case nil:
+		*a = Amount{}
+	case []byte:
+		*a, err = ParseAmount(string(v))
+	case string:
+		*a, err = ParseAmount(v)
+	case int64:
+		*a = Amount{units: v}
+	case float64:
+		*a, err = ParseAmount(strconv.FormatFloat(v, 'f', -1, 64))
+	default:
+		err = ErrInvalidAmount.With("opay.invalid_amount.type", value)
+	}
+	return
+}
+
+// Value implements the driver Valuer interface, as a decimal string.
+func (a Amount) Value() (driver.Value, error) {
+	return a.String(), nil
+}
+
+func (a Amount) big(scale uint8) *big.Int {
+	b := big.NewInt(a.units)
+	if scale > a.scale {
+		b.Mul(b, big.NewInt(pow10[scale-a.scale]))
+	}
+	return b
+}
+
+func (a Amount) rat() *big.Rat {
+	return new(big.Rat).SetFrac(big.NewInt(a.units), big.NewInt(pow10[a.scale]))
+}
+
+func fromBig(units *big.Int, scale uint8) Amount {
+	a, err := checkedFromBig(units, scale)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+func checkedFromBig(units *big.Int, scale uint8) (Amount, error) {
+	// Drops the trailing zeros if overflows, such as the sums of the DECIMAL(36,18) columns.
+	for !units.IsInt64() && scale > 0 {
+		quo, rem := new(big.Int).QuoRem(units, big.NewInt(10), new(big.Int))
+		if rem.Sign() != 0 {
+			break
+		}
+		units, scale = quo, scale-1
+	}
+	if !units.IsInt64() {
+		return Amount{}, ErrAmountOverflow
+	}
+	return Amount{units: units.Int64(), scale: scale}, nil
+}
+
+// Rounds half away from zero.
+func fromRat(r *big.Rat, scale int) Amount {
+	checkScale(scale)
+	num := new(big.Int).Mul(r.Num(), big.NewInt(pow10[scale]))
+	quo, rem := new(big.Int).QuoRem(num, r.Denom(), new(big.Int))
+	if rem.Sign() != 0 && new(big.Int).Abs(new(big.Int).Lsh(rem, 1)).Cmp(r.Denom()) >= 0 {
+		quo.Add(quo, big.NewInt(int64(num.Sign())))
+	}
+	return fromBig(quo, uint8(scale))
+}
+
+func maxScale(a, b Amount) uint8 {
+	if a.scale > b.scale {
+		return a.scale
+	}
+	return b.scale
+}
+
+func checkScale(scale int) {
+	if scale < 0 || scale > MAX_AMOUNT_SCALE {
+		panic(ErrAmountScale)
+	}
+}
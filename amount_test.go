@@ -0,0 +1,94 @@
+package opay
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParseAmount(t *testing.T) {
+	for s, want := range map[string]string{
+		"0":                      "0",
+		"12.34":                  "12.34",
+		"-0.05":                  "-0.05",
+		"+1.50":                  "1.50",
+		".5":                     "0.5",
+		"1.5e3":                  "1500",
+		"-25e-3":                 "-0.025",
+		"1.10000000000000000000": "1.100000000000000000",
+		"100.000000000000000000": "100.0000000000000000",
+		"9999999999.00000000000": "9999999999.00000000",
+		"0.000":                  "0.000",
+		"-0e5":                   "0",
+	} {
+		a, err := ParseAmount(s)
+		if err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		if a.String() != want {
+			t.Fatalf("%s: got %s, want %s", s, a, want)
+		}
+	}
+	for _, s := range []string{"", "-", "1.2.3", "abc", "1e", "0.0000000000000000001", "99999999999999999999", "1e50000000", "1e-50000000", "1e38"} {
+		if _, err := ParseAmount(s); err == nil {
+			t.Fatalf("%q: no error", s)
+		}
+	}
+}
+
+func TestAmountArithmetic(t *testing.T) {
+	a, b := MustParseAmount("10.10"), MustParseAmount("0.205")
+	if s := a.Add(b).String(); s != "10.305" {
+		t.Fatalf("add: %s", s)
+	}
+	if s := b.Sub(a).String(); s != "-9.895" {
+		t.Fatalf("sub: %s", s)
+	}
+	if s := a.Mul(b, 2).String(); s != "2.07" {
+		t.Fatalf("mul: %s", s)
+	}
+	if s := a.Div(MustParseAmount("3"), 2).String(); s != "3.37" {
+		t.Fatalf("div: %s", s)
+	}
+	if s := MustParseAmount("-2.345").Round(2).String(); s != "-2.35" {
+		t.Fatalf("round: %s", s)
+	}
+	if !MustParseAmount("1.0").Equal(NewAmount(100, 2)) || a.Cmp(b) != 1 || b.Neg().Sign() != -1 {
+		t.Fatal("compare")
+	}
+	if MustAmountFromFloat(0.1+0.2, 2).String() != "0.30" {
+		t.Fatal("from float")
+	}
+	for _, f := range []float64{math.NaN(), math.Inf(1), 1e300} {
+		if _, err := AmountFromFloat(f, 2); !errors.Is(err, ErrIncorrectAmount) {
+			t.Fatalf("from float %v: %v", f, err)
+		}
+	}
+
+	defer func() {
+		if recover() != ErrAmountOverflow {
+			t.Fatal("no overflow panic")
+		}
+	}()
+	NewAmount(1<<62, 0).Add(NewAmount(1<<62, 0))
+}
+
+func TestAmountJSON(t *testing.T) {
+	var v struct {
+		A Amount `json:"a"`
+		B Amount `json:"b"`
+	}
+	if err := json.Unmarshal([]byte(`{"a":12.30,"b":"-0.01"}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(v)
+	if string(b) != `{"a":12.30,"b":-0.01}` {
+		t.Fatalf("marshal: %s", b)
+	}
+
+	var a Amount
+	if err := a.Scan([]byte("100.0000")); err != nil || a.String() != "100.0000" {
+		t.Fatalf("scan: %s %v", a, err)
+	}
+}
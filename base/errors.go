@@ -0,0 +1,43 @@
+package base
+
+import (
+	"github.com/henrylee2cn/opay"
+)
+
+var (
+	ErrOrderidLength  = opay.NewError(6001, opay.VALIDATION, "base.orderid_length")
+	ErrOrderidAid     = opay.NewError(6002, opay.VALIDATION, "base.orderid_aid")
+	ErrMetaNil        = opay.NewError(6003, opay.INTERNAL, "base.meta_nil")
+	ErrTargetStatus   = opay.NewError(6004, opay.VALIDATION, "base.target_status")
+	ErrAidFormat      = opay.NewError(6005, opay.VALIDATION, "base.aid_format")
+	ErrNotImplemented = opay.NewError(6006, opay.INTERNAL, "base.not_implemented")
+	ErrSameStatus     = opay.NewError(6007, opay.CONFLICT, "base.same_status")
+	ErrInvalidDetails = opay.NewError(6008, opay.INTERNAL, "base.invalid_details")
+)
+
+func init() {
+	opay.RegCatalog(opay.ZH, opay.Catalog{
+		"base.orderid_length":         "订单号长度不正确",
+		"base.orderid_aid":            "订单号的资产段不正确",
+		"base.meta_nil":               "订单类型参数为空",
+		"base.target_status":          "无效的目标状态",
+		"base.aid_format":             "资产ID格式错误",
+		"base.not_implemented":        "*BaseOrder 未实现 opay.IOrder",
+		"base.not_implemented.detail": "*BaseOrder 未实现 opay.IOrder（缺少 %s 方法）",
+		"base.same_status":            "目标状态与当前状态相同",
+		"base.invalid_details":        "无效的订单明细",
+		"base.invalid_details.type":   "无法将类型 %T 转换为订单明细",
+	})
+	opay.RegCatalog(opay.EN, opay.Catalog{
+		"base.orderid_length":         "orderid is not the correct length.",
+		"base.orderid_aid":            "orderid's 'aid' section is incorrect.",
+		"base.meta_nil":               "Param meta can not be nil.",
+		"base.target_status":          "Target status is invalid.",
+		"base.aid_format":             "wrong aid format.",
+		"base.not_implemented":        "*BaseOrder does not implement opay.IOrder.",
+		"base.not_implemented.detail": "*BaseOrder does not implement opay.IOrder (missing %s method).",
+		"base.same_status":            "Target status and the current status is the same.",
+		"base.invalid_details":        "invalid details.",
+		"base.invalid_details.type":   "Cannot convert 'details' type %T to type 'Details'.",
+	})
+}
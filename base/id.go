@@ -1,7 +1,6 @@
 package base
 
 import (
-	"errors"
 	"fmt"
 	"math/rand"
 	"strings"
@@ -56,11 +55,11 @@ func GetAidFromOrderid(orderid string) string {
 
 func CheckOrderid(orderid string) (aid string, err error) {
 	if len(orderid) != 32 {
-		return "", errors.New("orderid is not the correct length.")
+		return "", ErrOrderidLength
 	}
 	aid = GetAidFromOrderid(orderid)
 	if len(aid) == 0 {
-		return "", errors.New("orderid's 'aid' section is incorrect.")
+		return "", ErrOrderidAid
 	}
 	return aid, nil
 }
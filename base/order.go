@@ -4,8 +4,6 @@ import (
 	"database/sql"
 	"database/sql/driver"
 	"encoding/json"
-	"errors"
-	"fmt"
 	"strings"
 	"sync"
 	"time"
@@ -25,9 +23,10 @@ type (
 		LinkUid string `json:"link_uid" db:"link_uid"`
 		Type    string `json:"type" db:"type"` //order type
 		//the amount of change for the Uid-Aid account, balance of positive and negative representation
-		Amount        float64 `json:"amount" db:"amount"`
-		Summary       string  `json:"summary" db:"summary"`
-		Details       Details `json:"details" db:"details"`
+		Amount        opay.Amount `json:"amount" db:"amount"`
+		Fee           opay.Amount `json:"fee" db:"fee"` //the service fee included in the amount
+		Summary       string      `json:"summary" db:"summary"`
+		Details       Details     `json:"details" db:"details"`
 		detailsString string
 		preStatus     int64 //the previous status
 		Status        int64 `json:"status" db:"status"` //the target status
@@ -46,7 +45,10 @@ type (
 	}
 )
 
-var _ opay.IOrder = new(BaseOrder)
+var (
+	_ opay.IOrder   = new(BaseOrder)
+	_ opay.FeeOrder = new(BaseOrder)
+)
 
 //note: if param note is empty, do not append detail;
 //and if param id is empty, the BaseOrder is new one.
@@ -54,7 +56,7 @@ func NewBaseOrderFromAid(
 	meta *opay.Meta,
 	aid string,
 	uid string,
-	amount float64,
+	amount opay.Amount,
 	summary string,
 	targetStatus int64,
 	ip string,
@@ -67,7 +69,7 @@ func NewBaseOrderFromId(
 	meta *opay.Meta,
 	id string,
 	uid string,
-	amount float64,
+	amount opay.Amount,
 	summary string,
 	targetStatus int64,
 	ip string,
@@ -80,26 +82,45 @@ func NewBaseOrderFromId(
 	return newBaseOrder(meta, id, aid, uid, amount, summary, targetStatus, ip, note...)
 }
 
+// NewRefundOrder creates a refund order of the original one,
+// linked by LinkId, and the amount should be opposite to the original's.
+func NewRefundOrder(
+	meta *opay.Meta,
+	original *BaseOrder,
+	amount opay.Amount,
+	summary string,
+	targetStatus int64,
+	ip string,
+	note ...string,
+) (*BaseOrder, error) {
+	o, err := newBaseOrder(meta, CreateOrderid(original.Aid), original.Aid, original.Uid, amount, summary, targetStatus, ip, note...)
+	if err != nil {
+		return nil, err
+	}
+	o.LinkId, o.LinkUid = original.Id, original.Uid
+	return o, nil
+}
+
 func newBaseOrder(
 	meta *opay.Meta,
 	id string,
 	aid string,
 	uid string,
-	amount float64,
+	amount opay.Amount,
 	summary string,
 	targetStatus int64,
 	ip string,
 	note ...string,
 ) (*BaseOrder, error) {
 	if meta == nil {
-		return nil, errors.New("Param meta can not be nil.")
+		return nil, ErrMetaNil
 	}
 	_, ok := meta.Status(targetStatus)
 	if !ok {
-		return nil, errors.New("Target status is invalid.")
+		return nil, ErrTargetStatus
 	}
 	if len(aid) == 0 || len(aid) > 2 || strings.HasPrefix(aid, "0") {
-		return nil, errors.New("wrong aid format.")
+		return nil, ErrAidFormat
 	}
 	var o = &BaseOrder{
 		Id:        id,
@@ -128,7 +149,7 @@ func (this *BaseOrder) GetMeta() *opay.Meta {
 // Specify the handler of dealing.
 func (this *BaseOrder) SetMeta(meta *opay.Meta) error {
 	if meta == nil {
-		return errors.New("Param meta can not be nil.")
+		return ErrMetaNil
 	}
 	this.meta = meta
 	return nil
@@ -155,44 +176,44 @@ func (this *BaseOrder) GetAid() string {
 
 // Get the amount of change for the Uid-Aid account,
 // balance of positive and negative representation.
-func (this *BaseOrder) GetAmount() float64 {
+func (this *BaseOrder) GetAmount() opay.Amount {
 	return this.Amount
 }
 
 // Async execution, and mark pending.
 func (this *BaseOrder) Pend(tx *sqlx.Tx, kv opay.KV) error {
-	return errors.New("*BaseOrder does not implement opay.IOrder (missing Pend method).")
+	return ErrNotImplemented.With("base.not_implemented.detail", "Pend")
 }
 
 // Async execution, and mark the doing.
 func (this *BaseOrder) Do(tx *sqlx.Tx, kv opay.KV) error {
-	return errors.New("*BaseOrder does not implement opay.IOrder (missing Do method).")
+	return ErrNotImplemented.With("base.not_implemented.detail", "Do")
 }
 
 // Async execution, and mark the successful.
 func (this *BaseOrder) Succeed(tx *sqlx.Tx, kv opay.KV) error {
-	return errors.New("*BaseOrder does not implement opay.IOrder (missing Succeed method).")
+	return ErrNotImplemented.With("base.not_implemented.detail", "Succeed")
 }
 
 // Async execution, and mark canceled.
 func (this *BaseOrder) Cancel(tx *sqlx.Tx, kv opay.KV) error {
-	return errors.New("*BaseOrder does not implement opay.IOrder (missing Cancel method).")
+	return ErrNotImplemented.With("base.not_implemented.detail", "Cancel")
 }
 
 // Async execution, and mark failure.
 func (this *BaseOrder) Fail(tx *sqlx.Tx, kv opay.KV) error {
-	return errors.New("*BaseOrder does not implement opay.IOrder (missing Fail method).")
+	return ErrNotImplemented.With("base.not_implemented.detail", "Fail")
 }
 
 // Sync execution, and mark the successful.
 func (this *BaseOrder) SyncDeal(tx *sqlx.Tx, kv opay.KV) error {
-	return errors.New("*BaseOrder does not implement opay.IOrder (missing SyncDeal method).")
+	return ErrNotImplemented.With("base.not_implemented.detail", "SyncDeal")
 }
 
 // Set the target Action.
 func (this *BaseOrder) SetTarget(targetStatus int64, ip string, note ...string) error {
 	if this.Status == targetStatus {
-		return errors.New("Target status and the current status is the same.")
+		return ErrSameStatus
 	}
 	this.preStatus, this.Status = this.Status, targetStatus
 
@@ -245,6 +266,16 @@ func (this *BaseOrder) GetId() string {
 	return this.Id
 }
 
+// Get the order's service fee.
+func (this *BaseOrder) GetFee() opay.Amount {
+	return this.Fee
+}
+
+// Set the order's service fee.
+func (this *BaseOrder) SetFee(fee opay.Amount) {
+	this.Fee = fee
+}
+
 // Get the order's summary.
 func (this *BaseOrder) GetSummary() string {
 	return this.Summary
@@ -260,6 +291,11 @@ func (this *BaseOrder) GetCreatedAt() int64 {
 	return this.CreatedAt
 }
 
+// Get the related order's 'uid'.
+func (this *BaseOrder) GetLinkUid() string {
+	return this.LinkUid
+}
+
 // Get the related order's 'aid'.
 func (this *BaseOrder) GetLinkAid() string {
 	if len(this.LinkId) == 0 {
@@ -277,7 +313,7 @@ var (
 func (this *Details) Scan(value interface{}) error {
 	v, ok := value.([]byte)
 	if !ok {
-		return fmt.Errorf("Cannot convert 'details' type %T to type 'Details'.", value)
+		return ErrInvalidDetails.With("base.invalid_details.type", value)
 	}
 	if len(v) == 0 {
 		if this != nil {
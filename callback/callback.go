@@ -0,0 +1,193 @@
+// Package callback handles the asynchronous notifications of the payment providers,
+// driving the orders in DO step to SUCCEED or FAIL through Opay.Do.
+//
+// A notification is verified by the provider's Verifier, decoded from its signed payload by the Decoder,
+// mapped to the order by the Resolver, checked against the order's amount,
+// and handled idempotently across the duplicates.
+package callback
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/henrylee2cn/opay"
+)
+
+type (
+	// Notification is an inbound notification of a payment provider.
+	// TxnId, Status and Amount are decoded from the verified Payload by Handle,
+	// so that they can not be forged.
+	Notification struct {
+		Provider  string
+		TxnId     string      //transaction id of the provider
+		Status    string      //transaction status of the provider
+		Amount    opay.Amount //the sign is ignored
+		Payload   []byte      //raw body, which is signed
+		Signature string
+	}
+
+	// Verifier verifies the signature of the notifications.
+	Verifier interface {
+		Verify(n *Notification) error
+	}
+
+	// Decoder decodes the transaction id, status and amount from the signed payload.
+	Decoder interface {
+		Decode(payload []byte) (txnId, status string, amount opay.Amount, err error)
+	}
+
+	// Resolver maps the notifications to the orders.
+	Resolver interface {
+		// Find loads the order bound to the provider transaction,
+		// whose TargetStatus is its current status.
+		Find(n *Notification) (opay.IOrder, error)
+		// Retarget returns the request moving the order to the target status.
+		Retarget(order opay.IOrder, target opay.Status, n *Notification) (opay.Request, error)
+	}
+
+	// Handler handles the notifications of the registered providers.
+	Handler struct {
+		opay      *opay.Opay
+		resolver  Resolver
+		providers map[string]*provider
+		mu        sync.RWMutex
+	}
+
+	provider struct {
+		verifier Verifier
+		decoder  Decoder
+		steps    map[string]opay.Step //provider status -> step
+	}
+)
+
+var (
+	// ErrUnknownProvider is returned if the provider is not registered.
+	ErrUnknownProvider = errors.New("callback: unknown provider.")
+	// ErrUnknownStatus is returned if the provider status is not mapped to a step.
+	ErrUnknownStatus = errors.New("callback: unknown provider status.")
+	// ErrSignature is returned if the signature is invalid.
+	ErrSignature = errors.New("callback: invalid signature.")
+	// ErrAmountMismatch is returned if the amount is different from the order's.
+	ErrAmountMismatch = errors.New("callback: amount mismatch.")
+	// ErrConflict is returned if the order has been finished in another step.
+	ErrConflict = errors.New("callback: the order has been finished in another step.")
+)
+
+// New creates a notification handler.
+func New(o *opay.Opay, resolver Resolver) *Handler {
+	return &Handler{
+		opay:      o,
+		resolver:  resolver,
+		providers: make(map[string]*provider),
+	}
+}
+
+// RegProvider registers the provider with its verifier and decoder,
+// and the mapping from its statuses to opay.DO, opay.SUCCEED or opay.FAIL.
+func (h *Handler) RegProvider(name string, verifier Verifier, decoder Decoder, steps map[string]opay.Step) error {
+	if verifier == nil {
+		return errors.New("callback: verifier of provider '" + name + "' can not be nil.")
+	}
+	if decoder == nil {
+		return errors.New("callback: decoder of provider '" + name + "' can not be nil.")
+	}
+	for status, step := range steps {
+		if step != opay.DO && step != opay.SUCCEED && step != opay.FAIL {
+			return fmt.Errorf("callback: invalid step %d of provider status '%s'.", step, status)
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.providers[name]; ok {
+		return errors.New("callback: repeat register provider: " + name)
+	}
+	h.providers[name] = &provider{
+		verifier: verifier,
+		decoder:  decoder,
+		steps:    steps,
+	}
+	return nil
+}
+
+// Handle verifies the notification and moves the order to the mapped step.
+// It returns nil for the duplicated notifications,
+// so the provider can be acknowledged whenever the error is nil.
+func (h *Handler) Handle(ctx context.Context, n *Notification) error {
+	h.mu.RLock()
+	p, ok := h.providers[n.Provider]
+	h.mu.RUnlock()
+	if !ok {
+		return ErrUnknownProvider
+	}
+	if err := p.verifier.Verify(n); err != nil {
+		return err
+	}
+	// Only the signed fields are trusted.
+	txnId, status, amount, err := p.decoder.Decode(n.Payload)
+	if err != nil {
+		return err
+	}
+	n.TxnId, n.Status, n.Amount = txnId, status, amount
+
+	step, ok := p.steps[n.Status]
+	if !ok {
+		return ErrUnknownStatus
+	}
+
+	order, err := h.resolver.Find(n)
+	if err != nil {
+		return err
+	}
+	target, done, err := check(order, step, n.Amount)
+	if done || err != nil {
+		return err
+	}
+
+	req, err := h.resolver.Retarget(order, target, n)
+	if err != nil {
+		return err
+	}
+	if len(req.IdempotencyKey) == 0 {
+		req.IdempotencyKey = "callback:" + n.Provider + ":" + n.TxnId + ":" + n.Status
+	}
+	resp := h.opay.DoContext(ctx, req)
+	switch {
+	case resp.Err == nil:
+		return nil
+	case errors.Is(resp.Err, opay.ErrReprocess), errors.Is(resp.Err, opay.ErrInvalidStep):
+		// Processed by the duplicated notification concurrently.
+		if order, err = h.resolver.Find(n); err != nil {
+			return err
+		}
+		if _, done, err = check(order, step, n.Amount); done || err != nil {
+			return err
+		}
+	}
+	return resp.Err
+}
+
+// Checks the order, returns the target status, or done if it is in the step already.
+func check(order opay.IOrder, step opay.Step, amount opay.Amount) (target opay.Status, done bool, err error) {
+	meta := order.GetMeta()
+	current, ok := meta.Status(order.TargetStatus())
+	if !ok {
+		return target, false, opay.ErrInvalidStatus
+	}
+	if current.Step == step {
+		return target, true, nil
+	}
+	switch current.Step {
+	case opay.SUCCEED, opay.SYNC_DEAL, opay.FAIL, opay.CANCEL:
+		return target, false, ErrConflict
+	}
+	if !order.GetAmount().Abs().Equal(amount.Abs()) {
+		return target, false, ErrAmountMismatch
+	}
+	target, ok = meta.StepStatus(step)
+	if !ok {
+		return target, false, fmt.Errorf("callback: no status of step %d in order type '%s'.", step, meta.OrderType())
+	}
+	return target, false, nil
+}
@@ -0,0 +1,125 @@
+package callback
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/henrylee2cn/opay"
+	"github.com/jmoiron/sqlx"
+)
+
+type order struct {
+	meta   *opay.Meta
+	status int64
+	amount opay.Amount
+}
+
+func (o *order) GetMeta() *opay.Meta              { return o.meta }
+func (o *order) PreStatus() int64                 { return o.status }
+func (o *order) TargetStatus() int64              { return o.status }
+func (o *order) GetUid() string                   { return "u1" }
+func (o *order) GetAid() string                   { return "1" }
+func (o *order) GetAmount() opay.Amount           { return o.amount }
+func (o *order) Pend(*sqlx.Tx, opay.KV) error     { return nil }
+func (o *order) Do(*sqlx.Tx, opay.KV) error       { return nil }
+func (o *order) Succeed(*sqlx.Tx, opay.KV) error  { return nil }
+func (o *order) Cancel(*sqlx.Tx, opay.KV) error   { return nil }
+func (o *order) Fail(*sqlx.Tx, opay.KV) error     { return nil }
+func (o *order) SyncDeal(*sqlx.Tx, opay.KV) error { return nil }
+
+func TestCheck(t *testing.T) {
+	meta, err := opay.NewOpay(nil, 1, 2).RegMeta("withdraw", opay.HandlerFunc(nil), []opay.Status{
+		{Code: 10, Step: opay.PEND},
+		{Code: 20, Step: opay.DO},
+		{Code: 30, Step: opay.SUCCEED},
+		{Code: 40, Step: opay.FAIL},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := &order{meta: meta, status: 20, amount: opay.MustParseAmount("-10.00")}
+
+	target, done, err := check(o, opay.SUCCEED, opay.MustParseAmount("10"))
+	if err != nil || done || target.Code != 30 {
+		t.Fatalf("succeed: %+v %v %v", target, done, err)
+	}
+	if _, _, err = check(o, opay.SUCCEED, opay.MustParseAmount("9.99")); err != ErrAmountMismatch {
+		t.Fatalf("amount: %v", err)
+	}
+
+	o.status = 30
+	if _, done, err = check(o, opay.SUCCEED, opay.MustParseAmount("10")); !done || err != nil {
+		t.Fatalf("duplicate: %v %v", done, err)
+	}
+	if _, _, err = check(o, opay.FAIL, opay.MustParseAmount("10")); err != ErrConflict {
+		t.Fatalf("conflict: %v", err)
+	}
+}
+
+func TestHMACVerifier(t *testing.T) {
+	v := NewHMACVerifier([]byte("secret"))
+	n := &Notification{Payload: []byte(`{"txn_id":"1"}`)}
+	n.Signature = v.Sign(n.Payload)
+	if err := v.Verify(n); err != nil {
+		t.Fatal(err)
+	}
+	n.Payload = []byte(`{"txn_id":"2"}`)
+	if err := v.Verify(n); err != ErrSignature {
+		t.Fatalf("tampered: %v", err)
+	}
+}
+
+type stopResolver struct {
+	found *Notification
+}
+
+var errStop = errors.New("stop")
+
+func (r *stopResolver) Find(n *Notification) (opay.IOrder, error) {
+	copied := *n
+	r.found = &copied
+	return nil, errStop
+}
+
+func (r *stopResolver) Retarget(opay.IOrder, opay.Status, *Notification) (opay.Request, error) {
+	return opay.Request{}, errStop
+}
+
+func TestHandleSignedFields(t *testing.T) {
+	r := &stopResolver{}
+	h := New(opay.NewOpay(nil, 1, 2), r)
+	v := NewHMACVerifier([]byte("secret"))
+	steps := map[string]opay.Step{"SUCCESS": opay.SUCCEED, "FAIL": opay.FAIL}
+	if err := h.RegProvider("p", nil, NewJSONDecoder("txn_id", "status", "amount"), steps); err == nil {
+		t.Fatal("nil verifier is registered")
+	}
+	if err := h.RegProvider("p", v, nil, steps); err == nil {
+		t.Fatal("nil decoder is registered")
+	}
+	if err := h.RegProvider("p", v, NewJSONDecoder("txn_id", "status", "amount"), steps); err != nil {
+		t.Fatal(err)
+	}
+
+	// The captured payload of a failed transaction, replayed with the forged fields.
+	payload := []byte(`{"txn_id":123,"status":"FAIL","amount":"10.00"}`)
+	n := &Notification{
+		Provider:  "p",
+		TxnId:     "456",
+		Status:    "SUCCESS",
+		Amount:    opay.MustParseAmount("1000"),
+		Payload:   payload,
+		Signature: v.Sign(payload),
+	}
+	if err := h.Handle(context.Background(), n); err != errStop {
+		t.Fatalf("handle: %v", err)
+	}
+	if r.found.TxnId != "123" || r.found.Status != "FAIL" || !r.found.Amount.Equal(opay.MustParseAmount("10")) {
+		t.Fatalf("decoded: %+v", r.found)
+	}
+
+	n.Payload = []byte(`{"txn_id":123,"status":"SUCCESS","amount":"10.00"}`)
+	if err := h.Handle(context.Background(), n); err != ErrSignature {
+		t.Fatalf("tampered: %v", err)
+	}
+}
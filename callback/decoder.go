@@ -0,0 +1,61 @@
+package callback
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/henrylee2cn/opay"
+)
+
+// JSONDecoder decodes the top-level fields of a JSON payload.
+type JSONDecoder struct {
+	TxnId  string //field name of the transaction id
+	Status string //field name of the transaction status
+	Amount string //field name of the amount, a JSON number or string
+}
+
+var _ Decoder = (*JSONDecoder)(nil)
+
+// NewJSONDecoder creates a JSON payload decoder with the field names.
+func NewJSONDecoder(txnId, status, amount string) *JSONDecoder {
+	return &JSONDecoder{
+		TxnId:  txnId,
+		Status: status,
+		Amount: amount,
+	}
+}
+
+// Decode implements Decoder.
+func (d *JSONDecoder) Decode(payload []byte) (txnId, status string, amount opay.Amount, err error) {
+	var fields map[string]json.RawMessage
+	if err = json.Unmarshal(payload, &fields); err != nil {
+		return
+	}
+	if txnId, err = d.text(fields, d.TxnId); err != nil {
+		return
+	}
+	if status, err = d.text(fields, d.Status); err != nil {
+		return
+	}
+	raw, ok := fields[d.Amount]
+	if !ok {
+		err = errors.New("callback: missing field '" + d.Amount + "' of the payload.")
+		return
+	}
+	err = amount.UnmarshalJSON(raw)
+	return
+}
+
+// Returns the string or number field as text.
+func (d *JSONDecoder) text(fields map[string]json.RawMessage, name string) (string, error) {
+	raw, ok := fields[name]
+	if !ok {
+		return "", errors.New("callback: missing field '" + name + "' of the payload.")
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, nil
+	}
+	return strings.TrimSpace(string(raw)), nil
+}
@@ -0,0 +1,50 @@
+package callback
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+)
+
+// HMACVerifier verifies the hex encoded HMAC signature of the payload.
+type HMACVerifier struct {
+	secret []byte
+	hash   func() hash.Hash
+}
+
+var _ Verifier = (*HMACVerifier)(nil)
+
+// NewHMACVerifier creates a HMAC-SHA256 verifier with the secret.
+func NewHMACVerifier(secret []byte) *HMACVerifier {
+	return &HMACVerifier{
+		secret: secret,
+		hash:   sha256.New,
+	}
+}
+
+// SetHash sets the hash function, such as sha1.New.
+func (v *HMACVerifier) SetHash(h func() hash.Hash) {
+	v.hash = h
+}
+
+// Sign returns the hex encoded signature of the payload.
+func (v *HMACVerifier) Sign(payload []byte) string {
+	mac := hmac.New(v.hash, v.secret)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify implements Verifier.
+func (v *HMACVerifier) Verify(n *Notification) error {
+	signature, err := hex.DecodeString(n.Signature)
+	if err != nil {
+		return ErrSignature
+	}
+	mac := hmac.New(v.hash, v.secret)
+	mac.Write(n.Payload)
+	if !hmac.Equal(mac.Sum(nil), signature) {
+		return ErrSignature
+	}
+	return nil
+}
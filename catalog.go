@@ -0,0 +1,209 @@
+package opay
+
+import (
+	"errors"
+	"strings"
+	"sync"
+)
+
+// Catalog maps the message keys to the formats of a language.
+type Catalog map[string]string
+
+// The built-in languages.
+const (
+	ZH = "zh"
+	EN = "en"
+)
+
+var catalogs = struct {
+	m    map[string]Catalog
+	lang string //the default language
+	mu   sync.RWMutex
+}{
+	m: map[string]Catalog{
+		ZH: {
+			"opay.timeout":                   "加入交易队列超时",
+			"opay.queue_closed":              "交易队列已关闭",
+			"opay.started":                   "交易服务已启动",
+			"opay.not_started":               "交易服务未启动",
+			"opay.queue_owner":               "交易队列属于其他交易服务",
+			"opay.queue_codec":               "交易队列的编解码器为空",
+			"opay.queue_owner_id":            "交易队列的实例标识须为1至64字节",
+			"opay.queue_tx":                  "持久化交易队列不支持携带事务的请求",
+			"opay.claim_lost":                "已失去队列中交易请求的处理权",
+			"opay.remote":                    "交易请求处理失败",
+			"opay.remote.detail":             "%s",
+			"opay.panic":                     "交易处理异常",
+			"opay.panic.detail":              "交易处理异常：%v",
+			"opay.unknown_meta":              "未注册的交易订单类型",
+			"opay.meta_registered":           "交易订单类型已注册",
+			"opay.meta_registered.detail":    "交易订单类型 '%s' 已注册",
+			"opay.invalid_handler":           "订单处理器须为函数或结构体类型",
+			"opay.unknown_step":              "无效的交易订单操作类型",
+			"opay.unknown_step.detail":       "无效的交易订单操作类型：%d",
+			"opay.unknown_status":            "交易订单状态不存在",
+			"opay.unknown_status.detail":     "交易订单类型 '%s' 不存在状态 %d",
+			"opay.settle_not_found":          "未找到资产账户操作函数",
+			"opay.settle_not_found.detail":   "未找到资产 '%s' 的账户操作函数",
+			"opay.settle_registered":         "资产账户操作函数已注册",
+			"opay.settle_registered.detail":  "资产 '%s' 的账户操作函数已注册",
+			"opay.freezer_not_found":         "未找到资产冻结操作接口",
+			"opay.freezer_not_found.detail":  "未找到资产 '%s' 的冻结操作接口",
+			"opay.freezer_registered":        "资产冻结操作接口已注册",
+			"opay.freezer_registered.detail": "资产 '%s' 的冻结操作接口已注册",
+			"opay.empty_settle":              "空资产的账户操作函数",
+			"opay.fee_account":               "未设置手续费账户",
+			"opay.invalid_status":            "无效的交易订单状态",
+			"opay.stakeholder_not_exist":     "关联订单不存在",
+			"opay.extra_stakeholder":         "多余的关联订单",
+			"opay.party_nil":                 "交易参与方订单为空",
+			"opay.not_zero_sum":              "各资产的交易金额之和不为0",
+			"opay.incorrect_amount":          "交易金额不正确",
+			"opay.invalid_amount":            "无效的交易金额",
+			"opay.invalid_amount.detail":     "无效的交易金额 %q",
+			"opay.invalid_amount.type":       "无法将类型 %T 转换为交易金额",
+			"opay.amount_overflow":           "交易金额溢出",
+			"opay.amount_overflow.detail":    "交易金额 %q 溢出",
+			"opay.amount_scale":              "交易金额的小数位数超出范围",
+			"opay.amount_scale.detail":       "交易金额 %q 的小数位数超过 %d 位",
+			"opay.quote_not_found":           "兑换报价不存在",
+			"opay.quote_expired":             "兑换报价已过期",
+			"opay.quote_mismatch":            "兑换金额与报价不符",
+			"opay.initiator_nil":             "交易订单为空",
+			"opay.different_step":            "关联订单的操作不一致",
+			"opay.different_type":            "关联订单的类型不一致",
+			"opay.extra_party":               "多余的交易参与方订单",
+			"opay.quote_consumed":            "兑换报价已被使用",
+			"opay.not_refundable":            "交易订单不可退款",
+			"opay.refund_exceeded":           "累计退款金额超过原订单金额",
+			"opay.illegal_step":              "非法的交易订单操作",
+			"opay.invalid_step":              "无效的交易订单操作",
+			"opay.invalid_transition":        "不允许的交易订单状态变更",
+			"opay.invalid_transition.detail": "交易订单类型 '%s' 不允许从 %s 变更为 %s",
+			"opay.cancel_step":               "交易订单不可撤销",
+			"opay.reprocess":                 "重复操作交易订单",
+			"opay.idempotency_conflict":      "幂等键已用于其他交易",
+		},
+		EN: {
+			"opay.timeout":                   "opay: add to queue timeout.",
+			"opay.queue_closed":              "opay: queue is closed.",
+			"opay.started":                   "opay: already started.",
+			"opay.not_started":               "opay: not started.",
+			"opay.queue_owner":               "opay: the queue belongs to another Opay.",
+			"opay.queue_codec":               "opay: DBQueue's codec can not be nil.",
+			"opay.queue_owner_id":            "opay: DBQueue's owner must be 1 to 64 bytes.",
+			"opay.queue_tx":                  "opay: DBQueue does not support the request with Tx.",
+			"opay.claim_lost":                "opay: the claim of the queued request has been lost.",
+			"opay.remote":                    "opay: failed to handle the request.",
+			"opay.remote.detail":             "%s",
+			"opay.panic":                     "opay panic.",
+			"opay.panic.detail":              "opay panic: %v",
+			"opay.unknown_meta":              "opay: order type is not registered.",
+			"opay.meta_registered":           "opay: repeat register order meta.",
+			"opay.meta_registered.detail":    "opay: repeat register order meta: %s",
+			"opay.invalid_handler":           "opay: handler must be func or struct type.",
+			"opay.unknown_step":              "opay: invalid Step.",
+			"opay.unknown_step.detail":       "opay: invalid Step: %d",
+			"opay.unknown_status":            "opay: order status is not exist.",
+			"opay.unknown_status.detail":     "opay: order type '%s' has no status %d.",
+			"opay.settle_not_found":          "opay: not found SettleFunc.",
+			"opay.settle_not_found.detail":   "opay: not found SettleFunc '%s'.",
+			"opay.settle_registered":         "opay: settleFunc has been registered.",
+			"opay.settle_registered.detail":  "opay: settleFunc '%s' has been registered.",
+			"opay.freezer_not_found":         "opay: not found Freezer.",
+			"opay.freezer_not_found.detail":  "opay: not found Freezer '%s'.",
+			"opay.freezer_registered":        "opay: freezer has been registered.",
+			"opay.freezer_registered.detail": "opay: freezer '%s' has been registered.",
+			"opay.empty_settle":              "opay: empty settle function.",
+			"opay.fee_account":               "opay: fee account is not set.",
+			"opay.invalid_status":            "opay: order status is invalid.",
+			"opay.stakeholder_not_exist":     "opay: stakeholder order is not exist.",
+			"opay.extra_stakeholder":         "opay: stakeholder order is extra.",
+			"opay.party_nil":                 "opay: request.Parties can not contain nil.",
+			"opay.not_zero_sum":              "opay: the sum of the amounts of each asset must be 0.",
+			"opay.incorrect_amount":          "opay: account operation amount is incorrect.",
+			"opay.invalid_amount":            "opay: invalid amount.",
+			"opay.invalid_amount.detail":     "opay: invalid amount %q.",
+			"opay.invalid_amount.type":       "opay: cannot convert type %T to type 'Amount'.",
+			"opay.amount_overflow":           "opay: amount overflows.",
+			"opay.amount_overflow.detail":    "opay: amount %q overflows.",
+			"opay.amount_scale":              "opay: the scale of amount is out of range.",
+			"opay.amount_scale.detail":       "opay: amount %q has more than %d decimal places.",
+			"opay.quote_not_found":           "opay: exchange quote is not found.",
+			"opay.quote_expired":             "opay: exchange quote is expired.",
+			"opay.quote_mismatch":            "opay: exchange amounts do not match the quote.",
+			"opay.initiator_nil":             "opay: request.Initiator can not be nil.",
+			"opay.different_step":            "opay: initiator's step and stakeholder's must be same.",
+			"opay.different_type":            "opay: initiator's type and stakeholder's must be same.",
+			"opay.extra_party":               "opay: party orders are extra.",
+			"opay.quote_consumed":            "opay: exchange quote has been used.",
+			"opay.not_refundable":            "opay: the order is not refundable.",
+			"opay.refund_exceeded":           "opay: refunded amount exceeds the original amount.",
+			"opay.illegal_step":              "opay: illegal step.",
+			"opay.invalid_step":              "opay: invalid operation.",
+			"opay.invalid_transition":        "opay: the status transition is not allowed.",
+			"opay.invalid_transition.detail": "opay: order type '%s' can not change from %s to %s.",
+			"opay.cancel_step":               "opay: the order cannot be canceled.",
+			"opay.reprocess":                 "opay: repeat process order.",
+			"opay.idempotency_conflict":      "opay: idempotency key is used by another operation.",
+		},
+	},
+	lang: ZH,
+}
+
+// RegCatalog adds the messages of the language, overriding the existing keys.
+func RegCatalog(lang string, catalog Catalog) {
+	catalogs.mu.Lock()
+	defer catalogs.mu.Unlock()
+	c, ok := catalogs.m[lang]
+	if !ok {
+		c = make(Catalog, len(catalog))
+		catalogs.m[lang] = c
+	}
+	for key, format := range catalog {
+		c[key] = format
+	}
+}
+
+// SetLang sets the default language of Error.Error(), which is ZH by default.
+func SetLang(lang string) {
+	catalogs.mu.Lock()
+	catalogs.lang = lang
+	catalogs.mu.Unlock()
+}
+
+// Lang returns the default language.
+func Lang() string {
+	catalogs.mu.RLock()
+	defer catalogs.mu.RUnlock()
+	return catalogs.lang
+}
+
+// Localize returns the message of err in the language,
+// the first typed error in the chain and its causes are translated.
+func Localize(err error, lang string) string {
+	var e *Error
+	if !errors.As(err, &e) {
+		return err.Error()
+	}
+	msg := e.Message(lang)
+	if e.Cause != nil {
+		msg += ": " + Localize(e.Cause, lang)
+	}
+	// The typed error may be wrapped by the others, such as fmt.Errorf("...: %w", e).
+	return strings.Replace(err.Error(), e.Error(), msg, 1)
+}
+
+// Returns the format of the key in the language,
+// falls back to the default language, then the key itself.
+func lookup(lang, key string) string {
+	catalogs.mu.RLock()
+	defer catalogs.mu.RUnlock()
+	if format, ok := catalogs.m[lang][key]; ok {
+		return format
+	}
+	if format, ok := catalogs.m[catalogs.lang][key]; ok {
+		return format
+	}
+	return key
+}
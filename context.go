@@ -1,6 +1,7 @@
 package opay
 
 import (
+	"context"
 	"time"
 )
 
@@ -8,129 +9,234 @@ import (
 type Context struct {
 	initiatorSettle   SettleFunc
 	stakeholderSettle SettleFunc
+	partySettles      []SettleFunc
+	opay              *Opay
+	fee               Amount //the fee of the initiator returned by Fee, credited to the fee account
 	Request
 	*Response
 	*Floater
 }
 
+var _ KV = (*Context)(nil)
+
 // Deadline gets processing deadline, not limited if not fill.
 func (ctx *Context) Deadline() time.Time {
 	return ctx.Request.Deadline
 }
 
+// Now returns the current time of the Opay's clock.
+func (ctx *Context) Now() time.Time {
+	return ctx.opay.Now()
+}
+
 // Pend creates an order, and marks it as pending.
 func (ctx *Context) Pend() error {
-	if ctx.Request.Stakeholder != nil {
-		err := ctx.Request.Stakeholder.Pend(ctx.Request.Tx, ctx)
-		if err != nil {
-			return err
-		}
-	}
-	return ctx.Request.Initiator.Pend(ctx.Request.Tx, ctx)
+	return ctx.each(func(order IOrder) error {
+		return order.Pend(ctx.Request.Tx, ctx)
+	})
 }
 
 // Do marks the order as being in progress, and maybe have an associated asynchronous callback operation.
 func (ctx *Context) Do() error {
-	if ctx.Request.Stakeholder != nil {
-		err := ctx.Request.Stakeholder.Do(ctx.Request.Tx, ctx)
-		if err != nil {
-			return err
-		}
-	}
-	return ctx.Request.Initiator.Do(ctx.Request.Tx, ctx)
+	return ctx.each(func(order IOrder) error {
+		return order.Do(ctx.Request.Tx, ctx)
+	})
 }
 
 // Succeed processes the account and marks the order as successful.
 func (ctx *Context) Succeed() error {
-	if ctx.Request.Stakeholder != nil {
-		err := ctx.Request.Stakeholder.Succeed(ctx.Request.Tx, ctx)
-		if err != nil {
-			return err
-		}
-	}
-	return ctx.Request.Initiator.Succeed(ctx.Request.Tx, ctx)
+	return ctx.each(func(order IOrder) error {
+		return order.Succeed(ctx.Request.Tx, ctx)
+	})
 }
 
 // Cancel marks the order as Canceled.
 func (ctx *Context) Cancel() error {
-	if ctx.Request.Stakeholder != nil {
-		err := ctx.Request.Stakeholder.Cancel(ctx.Request.Tx, ctx)
-		if err != nil {
-			return err
-		}
-	}
-	return ctx.Request.Initiator.Cancel(ctx.Request.Tx, ctx)
+	return ctx.each(func(order IOrder) error {
+		return order.Cancel(ctx.Request.Tx, ctx)
+	})
 }
 
 // Fail marks the order as failed.
 func (ctx *Context) Fail() error {
-	if ctx.Request.Stakeholder != nil {
-		err := ctx.Request.Stakeholder.Fail(ctx.Request.Tx, ctx)
-		if err != nil {
-			return err
-		}
-	}
-	return ctx.Request.Initiator.Fail(ctx.Request.Tx, ctx)
+	return ctx.each(func(order IOrder) error {
+		return order.Fail(ctx.Request.Tx, ctx)
+	})
 }
 
 // SyncDeal The order is processed synchronously and marked as a successful status.
 func (ctx *Context) SyncDeal() error {
-	if ctx.Request.Stakeholder != nil {
-		err := ctx.Request.Stakeholder.SyncDeal(ctx.Request.Tx, ctx)
-		if err != nil {
-			return err
-		}
-	}
-	return ctx.Request.Initiator.SyncDeal(ctx.Request.Tx, ctx)
+	return ctx.each(func(order IOrder) error {
+		return order.SyncDeal(ctx.Request.Tx, ctx)
+	})
 }
 
 func (ctx *Context) HasStakeholder() bool {
 	return ctx.Request.Stakeholder != nil
 }
 
-// Modify the account balance.
-func (ctx *Context) UpdateBalance() error {
+// HasParties reports whether the request has party orders.
+func (ctx *Context) HasParties() bool {
+	return len(ctx.Request.Parties) > 0
+}
+
+// Orders returns all the orders of the request in processing order:
+// the stakeholder, the parties, then the initiator.
+func (ctx *Context) Orders() []IOrder {
+	orders, _ := ctx.orders()
+	return orders
+}
+
+// Returns the orders in processing order, and their settle functions.
+func (ctx *Context) orders() ([]IOrder, []SettleFunc) {
+	var (
+		orders  = make([]IOrder, 0, len(ctx.Request.Parties)+2)
+		settles = make([]SettleFunc, 0, len(ctx.Request.Parties)+2)
+	)
 	if ctx.Request.Stakeholder != nil {
-		err := ctx.stakeholderSettle(
-			ctx.Request.Stakeholder.GetUid(),
-			ctx.Request.Stakeholder.GetAmount(),
-			ctx.Request.Tx,
-		)
+		orders = append(orders, ctx.Request.Stakeholder)
+		settles = append(settles, ctx.stakeholderSettle)
+	}
+	orders = append(orders, ctx.Request.Parties...)
+	settles = append(settles, ctx.partySettles...)
+	orders = append(orders, ctx.Request.Initiator)
+	settles = append(settles, ctx.initiatorSettle)
+	return orders, settles
+}
+
+// Calls fn for each order in processing order.
+func (ctx *Context) each(fn func(order IOrder) error) error {
+	for _, order := range ctx.Orders() {
+		err := fn(order)
 		if err != nil {
 			return err
 		}
 	}
-	return ctx.initiatorSettle(
-		ctx.Request.Initiator.GetUid(),
-		ctx.Request.Initiator.GetAmount(),
-		ctx.Request.Tx,
-	)
+	return nil
+}
+
+// Modify the account balance,
+// including the fee returned by Fee, which is credited to the fee account.
+func (ctx *Context) UpdateBalance() error {
+	return ctx.settle(false)
 }
 
-// Roll back the account balance.
+// Roll back the account balance, including the fee returned by Fee.
 func (ctx *Context) RollbackBalance() error {
-	if ctx.Request.Stakeholder != nil {
-		err := ctx.stakeholderSettle(
-			ctx.Request.Stakeholder.GetUid(),
-			-ctx.Request.Stakeholder.GetAmount(),
-			ctx.Request.Tx,
-		)
+	return ctx.settle(true)
+}
+
+// FreezeBalance freezes the amount of the initiator's account, amount is positive.
+func (ctx *Context) FreezeBalance(amount Amount) error {
+	freezer, err := ctx.opay.GetFreezer(ctx.Request.Initiator.GetAid())
+	if err != nil {
+		return err
+	}
+	return freezer.Freeze(ctx.Request.Initiator.GetUid(), amount, ctx.Request.Tx)
+}
+
+// UnfreezeBalance releases the frozen amount of the initiator's account, amount is positive.
+func (ctx *Context) UnfreezeBalance(amount Amount) error {
+	freezer, err := ctx.opay.GetFreezer(ctx.Request.Initiator.GetAid())
+	if err != nil {
+		return err
+	}
+	return freezer.Unfreeze(ctx.Request.Initiator.GetUid(), amount, ctx.Request.Tx)
+}
+
+// CaptureBalance is like UpdateBalance,
+// but debits the initiator's amount from the frozen balance instead of the available one,
+// and settles the other orders and the fee as usual.
+func (ctx *Context) CaptureBalance() error {
+	initiator := ctx.Request.Initiator
+	if initiator.GetAmount().Sign() >= 0 {
+		return ErrIncorrectAmount
+	}
+	freezer, err := ctx.opay.GetFreezer(initiator.GetAid())
+	if err != nil {
+		return err
+	}
+	err = freezer.Capture(initiator.GetUid(), initiator.GetAmount().Neg(), ctx.Request.Tx)
+	if err != nil {
+		return err
+	}
+	posting := &Posting{
+		OrderId:   OrderId(initiator),
+		OrderType: ctx.Request.Operator(),
+		Step:      ctx.Request.Step(),
+	}
+	orders, settles := ctx.orders()
+	for i, order := range orders[:len(orders)-1] {
+		err = settles[i](order.GetUid(), order.GetAmount(), ctx.Request.Tx)
 		if err != nil {
 			return err
 		}
+		posting.Changes = append(posting.Changes, BalanceChange{
+			OrderId: OrderId(order),
+			Uid:     order.GetUid(),
+			Aid:     order.GetAid(),
+			Amount:  order.GetAmount(),
+		})
+	}
+	posting.Changes = append(posting.Changes, BalanceChange{
+		OrderId: OrderId(initiator),
+		Uid:     initiator.GetUid(),
+		Aid:     initiator.GetAid(),
+		Amount:  initiator.GetAmount(),
+	})
+	fee, err := ctx.settleFee(false)
+	if err != nil {
+		return err
 	}
+	if fee != nil {
+		posting.Changes = append(posting.Changes, *fee)
+	}
+	return ctx.opay.post(ctx.Request.Tx, posting)
+}
 
-	return ctx.initiatorSettle(
-		ctx.Request.Initiator.GetUid(),
-		-ctx.Request.Initiator.GetAmount(),
-		ctx.Request.Tx,
+// Settles the amount of the orders and the fee, or the opposite if rollback,
+// then posts the changes to the journal in one posting.
+func (ctx *Context) settle(rollback bool) error {
+	var (
+		orders, settles = ctx.orders()
+		posting         = &Posting{
+			OrderId:   OrderId(ctx.Request.Initiator),
+			OrderType: ctx.Request.Operator(),
+			Step:      ctx.Request.Step(),
+		}
 	)
+	for i, order := range orders {
+		amount := order.GetAmount()
+		if rollback {
+			amount = amount.Neg()
+		}
+		err := settles[i](order.GetUid(), amount, ctx.Request.Tx)
+		if err != nil {
+			return err
+		}
+		posting.Changes = append(posting.Changes, BalanceChange{
+			OrderId: OrderId(order),
+			Uid:     order.GetUid(),
+			Aid:     order.GetAid(),
+			Amount:  amount,
+		})
+	}
+	fee, err := ctx.settleFee(rollback)
+	if err != nil {
+		return err
+	}
+	if fee != nil {
+		posting.Changes = append(posting.Changes, *fee)
+	}
+	return ctx.opay.post(ctx.Request.Tx, posting)
 }
 
 // KV key-value
 type KV interface {
 	Get(k string) interface{}
 	Set(k string, v interface{})
+	// Context returns the request's context.Context.
+	Context() context.Context
 }
 
 // Get gets a temporary variable.
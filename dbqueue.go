@@ -0,0 +1,479 @@
+package opay
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type (
+	// RequestCodec encodes the requests to persist, and decodes them back.
+	// The decoded orders must be bound to the registered Meta.
+	RequestCodec interface {
+		Encode(*Request) ([]byte, error)
+		Decode([]byte) (Request, error)
+	}
+
+	// DBQueue is a durable queue persisting the requests to a table.
+	// Several Opay instances can share the same table,
+	// and the unfinished requests are recovered on restart.
+	// Requests with Tx are not supported.
+	DBQueue struct {
+		db        *sqlx.DB
+		dialect   Dialect
+		table     string
+		codec     RequestCodec
+		owner     string             //unique id of the Opay instance
+		capacity  int                //used to limit the concurrency
+		lease     time.Duration      //the claims older than it are recovered
+		interval  time.Duration      //polling interval
+		waiting   map[int64]*Request //requests pushed by this instance, not yet claimed or finished by it
+		recovered time.Time          //last time of recovering
+		mu        sync.Mutex
+		wake      chan struct{}
+		closing   chan struct{}
+		once      sync.Once
+		opay      *Opay
+	}
+)
+
+// The states of the persisted requests.
+const (
+	queuePending    = "pending"
+	queueProcessing = "processing"
+	queueDone       = "done"
+	queueFailed     = "failed"
+)
+
+const (
+	DEFAULT_QUEUE_LEASE    = 5 * time.Minute // DEFAULT_QUEUE_LEASE is the default lease of the claimed requests
+	DEFAULT_QUEUE_INTERVAL = time.Second     // DEFAULT_QUEUE_INTERVAL is the default polling interval
+)
+
+var _ Queue = (*DBQueue)(nil)
+
+// NewDBQueue creates a durable queue of opay on the table created by CreateDBQueueTable,
+// and recovers the requests claimed by the same owner before.
+func NewDBQueue(opay *Opay, table string, codec RequestCodec, owner string) (*DBQueue, error) {
+	if codec == nil {
+		return nil, ErrQueueCodec
+	}
+	if len(owner) == 0 || len(owner) > 64 {
+		return nil, ErrQueueOwnerId
+	}
+	q := &DBQueue{
+		db:       opay.DB(),
+		dialect:  DialectOf(opay.DB().DriverName()),
+		table:    table,
+		codec:    codec,
+		owner:    owner,
+		capacity: DEFAULT_QUEUE_CAP,
+		lease:    DEFAULT_QUEUE_LEASE,
+		interval: DEFAULT_QUEUE_INTERVAL,
+		waiting:  make(map[int64]*Request),
+		wake:     make(chan struct{}, 1),
+		closing:  make(chan struct{}),
+		opay:     opay,
+	}
+	if err := q.Recover(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// CreateDBQueueTable creates the table of DBQueue if not exists.
+func CreateDBQueueTable(db *sqlx.DB, table string) error {
+	dialect := DialectOf(db.DriverName())
+	return dialect.Exec(db, dialect.CreateTable(table, []string{
+		"id " + dialect.AutoIncrementKey(),
+		"payload " + dialect.Blob() + " NOT NULL",
+		"state VARCHAR(16) NOT NULL",
+		"owner VARCHAR(64) NOT NULL DEFAULT ''",
+		"deadline BIGINT NOT NULL DEFAULT 0",
+		"err_code INT NOT NULL DEFAULT 0",
+		"err_category VARCHAR(16) NOT NULL DEFAULT ''",
+		"err_msg VARCHAR(1024) NOT NULL DEFAULT ''",
+		"created_at BIGINT NOT NULL",
+		"updated_at BIGINT NOT NULL",
+	}, "state, id"))
+}
+
+// SetLease sets the lease of the claimed requests,
+// the requests claimed by a dead instance are recovered after it.
+func (q *DBQueue) SetLease(lease time.Duration) {
+	q.mu.Lock()
+	q.lease = lease
+	q.mu.Unlock()
+}
+
+// SetInterval sets the polling interval.
+func (q *DBQueue) SetInterval(interval time.Duration) {
+	q.mu.Lock()
+	q.interval = interval
+	q.mu.Unlock()
+}
+
+// GetCap returns queue capacity, which is only used to limit the concurrency.
+func (q *DBQueue) GetCap() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.capacity
+}
+
+// Len returns the number of the pending orders of all the instances, or -1 if the query fails.
+func (q *DBQueue) Len() int {
+	var n int
+	err := q.db.Get(&n, q.db.Rebind("SELECT COUNT(*) FROM "+q.table+" WHERE state = ?"), queuePending)
+	if err != nil {
+		q.opay.logger.Printf("opay: DBQueue: %v", err)
+		return -1
+	}
+	return n
+}
+
+// SetCap sets the queue capacity.
+func (q *DBQueue) SetCap(queueCapacity int) {
+	if queueCapacity <= 0 {
+		queueCapacity = DEFAULT_QUEUE_CAP
+	}
+	q.mu.Lock()
+	q.capacity = queueCapacity
+	q.mu.Unlock()
+}
+
+// Push persists an order.
+func (q *DBQueue) Push(req Request) (respChan <-chan *Response) {
+	respChan, err := req.prepare(q.opay)
+	if err == nil {
+		err = q.push(&req)
+	}
+	if err != nil {
+		req.setError(err)
+		req.writeback()
+	}
+	return
+}
+
+func (q *DBQueue) push(req *Request) error {
+	select {
+	case <-q.closing:
+		return ErrQueueClosed
+	default:
+	}
+	if req.Tx != nil {
+		return ErrQueueTx
+	}
+	if _, err := checkTimeout(req.Deadline); err != nil {
+		return err
+	}
+	if err := req.Context().Err(); err != nil {
+		return err
+	}
+	payload, err := q.codec.Encode(req)
+	if err != nil {
+		return err
+	}
+	var deadline int64
+	if !req.Deadline.IsZero() {
+		deadline = req.Deadline.UnixNano()
+	}
+	now := q.opay.Now().Unix()
+
+	// Holds the lock until registered, so that the claimer finds it.
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	id, err := q.dialect.InsertId(q.db,
+		"INSERT INTO "+q.table+" (payload, state, deadline, created_at, updated_at) VALUES (?, ?, ?, ?, ?)",
+		payload, queuePending, deadline, now, now,
+	)
+	if err != nil {
+		return err
+	}
+	q.waiting[id] = req
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Pull claims an order.
+// Wait until a valid order is taken,
+// or the queue is closed and the orders pushed by this instance are finished.
+func (q *DBQueue) Pull() (Request, bool) {
+	var closed bool
+	for {
+		req, ok, err := q.claim()
+		if err == ErrClaimLost {
+			// Claimed by another instance meanwhile, try the next one.
+			continue
+		}
+		if err != nil {
+			q.opay.logger.Printf("opay: DBQueue claim: %v", err)
+		} else if ok {
+			return req, true
+		}
+
+		q.mu.Lock()
+		interval := q.interval
+		recoverable := time.Since(q.recovered) > q.lease/2
+		q.mu.Unlock()
+
+		if recoverable {
+			// The claims of this instance are in processing.
+			if err := q.recoverClaims(""); err != nil {
+				q.opay.logger.Printf("opay: DBQueue recover: %v", err)
+			}
+		}
+		q.collect()
+
+		if closed {
+			q.mu.Lock()
+			n := len(q.waiting)
+			q.mu.Unlock()
+			if n == 0 {
+				return Request{}, false
+			}
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-q.wake:
+		case <-timer.C:
+		case <-q.closing:
+			closed = true
+		}
+		timer.Stop()
+	}
+}
+
+// Close stops accepting new orders.
+func (q *DBQueue) Close() {
+	q.once.Do(func() {
+		close(q.closing)
+	})
+}
+
+// GetOpay returns Opay
+func (q *DBQueue) GetOpay() *Opay {
+	return q.opay
+}
+
+// Recover puts back the requests claimed by this owner before,
+// and the ones whose claim is older than the lease.
+func (q *DBQueue) Recover() error {
+	return q.recoverClaims(q.owner)
+}
+
+// Puts back the requests claimed by the owner, and the expired ones.
+func (q *DBQueue) recoverClaims(owner string) error {
+	q.mu.Lock()
+	q.recovered = time.Now()
+	expired := q.recovered.Add(-q.lease).Unix()
+	q.mu.Unlock()
+	_, err := q.db.Exec(q.db.Rebind(
+		"UPDATE "+q.table+" SET state = ?, owner = '' WHERE state = ? AND (owner = ? OR updated_at < ?)"),
+		queuePending, queueProcessing, owner, expired,
+	)
+	return err
+}
+
+// Claims a pending request with row locking,
+// returns ErrClaimLost if another instance claims it first without row locking.
+func (q *DBQueue) claim() (req Request, ok bool, err error) {
+	var row struct {
+		Id       int64  `db:"id"`
+		Payload  []byte `db:"payload"`
+		Deadline int64  `db:"deadline"`
+	}
+	tx, err := q.db.Beginx()
+	if err != nil {
+		return
+	}
+	err = tx.Get(&row, tx.Rebind(
+		"SELECT id, payload, deadline FROM "+q.table+" WHERE state = ? ORDER BY id LIMIT 1"+q.dialect.ForUpdate(true)),
+		queuePending,
+	)
+	if err == nil {
+		var result sql.Result
+		result, err = tx.Exec(tx.Rebind(
+			"UPDATE "+q.table+" SET state = ?, owner = ?, updated_at = ? WHERE id = ? AND state = ?"),
+			queueProcessing, q.owner, q.opay.Now().Unix(), row.Id, queuePending,
+		)
+		if err == nil {
+			var n int64
+			if n, err = result.RowsAffected(); err == nil && n == 0 {
+				err = ErrClaimLost
+			}
+		}
+	}
+	if err != nil {
+		tx.Rollback()
+		if err == sql.ErrNoRows {
+			err = nil
+		}
+		return
+	}
+	if err = tx.Commit(); err != nil {
+		return
+	}
+
+	q.mu.Lock()
+	origin := q.waiting[row.Id]
+	delete(q.waiting, row.Id)
+	q.mu.Unlock()
+
+	var respChan <-chan *Response
+	if origin != nil {
+		// Pushed by this instance, the origin is notified when finished.
+		req = origin.clone()
+		respChan = req.renewResponse()
+	} else {
+		req, err = q.codec.Decode(row.Payload)
+		if err == nil {
+			respChan, err = req.prepare(q.opay)
+		}
+		if err != nil {
+			q.finish(row.Id, err)
+			return req, false, err
+		}
+		if row.Deadline > 0 {
+			req.Deadline = time.Unix(0, row.Deadline)
+		}
+	}
+	req.ack = func(tx *sqlx.Tx) error {
+		return q.ack(tx, row.Id)
+	}
+	go q.track(row.Id, respChan, origin)
+
+	// If timeout, cancel the order.
+	if _, err = checkTimeout(req.Deadline); err == nil {
+		err = req.Context().Err()
+	}
+	if err != nil {
+		req.setError(err)
+		req.writeback()
+		return req, false, nil
+	}
+	return req, true, nil
+}
+
+// Marks the request done in the handler's transaction.
+// Fails if the claim has been recovered by others.
+func (q *DBQueue) ack(tx *sqlx.Tx, id int64) error {
+	result, err := tx.Exec(tx.Rebind(
+		"UPDATE "+q.table+" SET state = ?, updated_at = ? WHERE id = ? AND state = ? AND owner = ?"),
+		queueDone, q.opay.Now().Unix(), id, queueProcessing, q.owner,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err == nil && n == 0 {
+		err = ErrClaimLost
+	}
+	return err
+}
+
+// Records the result, and notifies the origin request.
+func (q *DBQueue) track(id int64, respChan <-chan *Response, origin *Request) {
+	resp := <-respChan
+	q.finish(id, resp.Err)
+	if origin != nil {
+		origin.setError(resp.Err)
+		origin.writeback()
+	}
+}
+
+// Records the result of the claimed request.
+func (q *DBQueue) finish(id int64, err error) {
+	var (
+		state       = queueDone
+		errCode     int
+		errCategory Category
+		errMsg      string
+	)
+	if err != nil {
+		state = queueFailed
+		var e *Error
+		if errors.As(err, &e) {
+			errCode, errCategory = e.Code, e.Category
+		}
+		errMsg = err.Error()
+		if len(errMsg) > 1024 {
+			errMsg = errMsg[:1024]
+		}
+	}
+	_, err = q.db.Exec(q.db.Rebind(
+		"UPDATE "+q.table+" SET state = ?, err_code = ?, err_category = ?, err_msg = ?, updated_at = ? WHERE id = ? AND state = ? AND owner = ?"),
+		state, errCode, errCategory, errMsg, q.opay.Now().Unix(), id, queueProcessing, q.owner,
+	)
+	if err != nil {
+		q.opay.logger.Printf("opay: DBQueue finish: %v", err)
+	}
+}
+
+// Notifies the requests pushed by this instance and finished by others.
+func (q *DBQueue) collect() {
+	q.mu.Lock()
+	ids := make([]interface{}, 0, len(q.waiting))
+	for id := range q.waiting {
+		ids = append(ids, id)
+	}
+	q.mu.Unlock()
+
+	const batch = 100
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > batch {
+			n = batch
+		}
+		var rows []struct {
+			Id          int64    `db:"id"`
+			State       string   `db:"state"`
+			ErrCode     int      `db:"err_code"`
+			ErrCategory Category `db:"err_category"`
+			ErrMsg      string   `db:"err_msg"`
+		}
+		err := q.db.Select(&rows, q.db.Rebind(
+			"SELECT id, state, err_code, err_category, err_msg FROM "+q.table+" WHERE state IN (?, ?) AND id IN (?"+strings.Repeat(", ?", n-1)+")"),
+			append([]interface{}{queueDone, queueFailed}, ids[:n]...)...,
+		)
+		ids = ids[n:]
+		if err != nil {
+			q.opay.logger.Printf("opay: DBQueue collect: %v", err)
+			return
+		}
+		for _, row := range rows {
+			q.mu.Lock()
+			origin := q.waiting[row.Id]
+			delete(q.waiting, row.Id)
+			q.mu.Unlock()
+			if origin == nil {
+				continue
+			}
+			if row.State == queueFailed {
+				origin.setError(remoteError(row.ErrCode, row.ErrCategory, row.ErrMsg))
+			}
+			origin.writeback()
+		}
+	}
+}
+
+// Rebuilds the error of the request failed in another instance,
+// which matches the original with errors.Is if it is an *Error.
+func remoteError(code int, category Category, msg string) error {
+	if code == 0 {
+		return ErrRemote.With("opay.remote.detail", msg)
+	}
+	return &Error{
+		Code:     code,
+		Category: category,
+		Key:      "opay.remote.detail",
+		Args:     []interface{}{msg},
+	}
+}
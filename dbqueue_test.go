@@ -0,0 +1,208 @@
+package opay
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Encodes the uid of the initiator, and decodes the test request of meta.
+type testCodec struct {
+	meta *Meta
+}
+
+func (c testCodec) Encode(req *Request) ([]byte, error) { return []byte(req.Initiator.GetUid()), nil }
+func (c testCodec) Decode(b []byte) (Request, error) {
+	return newTestRequest(c.meta, string(b), 1), nil
+}
+
+// Returns the opay with meta "test" and the DBQueue of each owner on the same table.
+func newTestDBQueues(t *testing.T, owners ...string) (*Opay, *Meta, []*DBQueue) {
+	db := newTestDB(t)
+	o := New(db)
+	meta, err := o.RegMeta("test", HandlerFunc(nil), []Status{
+		{Code: 1, Note: "pend", Step: PEND},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = CreateDBQueueTable(db, "opay_queue"); err != nil {
+		t.Fatal(err)
+	}
+	queues := make([]*DBQueue, len(owners))
+	for i, owner := range owners {
+		if queues[i], err = NewDBQueue(o, "opay_queue", testCodec{meta}, owner); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return o, meta, queues
+}
+
+// Pushes a test request to q, returns its id and response channel.
+func pushTestRequest(t *testing.T, q *DBQueue, meta *Meta, uid string) (int64, <-chan *Response) {
+	req := newTestRequest(meta, uid, 1)
+	respChan, err := req.prepare(q.opay)
+	if err == nil {
+		err = q.push(&req)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	var id int64
+	if err = q.db.Get(&id, "SELECT MAX(id) FROM opay_queue"); err != nil {
+		t.Fatal(err)
+	}
+	return id, respChan
+}
+
+// Checks the state and owner of the persisted request.
+func checkQueueState(t *testing.T, db *sqlx.DB, id int64, state, owner string) {
+	t.Helper()
+	var row struct {
+		State string `db:"state"`
+		Owner string `db:"owner"`
+	}
+	if err := db.Get(&row, "SELECT state, owner FROM opay_queue WHERE id = ?", id); err != nil {
+		t.Fatal(err)
+	}
+	if row.State != state || row.Owner != owner {
+		t.Fatalf("request %d: %+v, want %s by %q", id, row, state, owner)
+	}
+}
+
+// Acks the claimed request in a transaction.
+func ackTestRequest(q *DBQueue, id int64) error {
+	tx, err := q.db.Beginx()
+	if err != nil {
+		return err
+	}
+	if err = q.ack(tx, id); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func TestDBQueueClaim(t *testing.T) {
+	o, meta, queues := newTestDBQueues(t, "a", "b")
+	a, b := queues[0], queues[1]
+	id, respChan := pushTestRequest(t, a, meta, "u1")
+	checkQueueState(t, o.DB(), id, queuePending, "")
+
+	// Claimed by the other instance, decoded from the payload.
+	req, ok, err := b.claim()
+	if err != nil || !ok || req.Initiator.GetUid() != "u1" {
+		t.Fatalf("claim: %v %v %+v", ok, err, req)
+	}
+	checkQueueState(t, o.DB(), id, queueProcessing, "b")
+	if _, ok, err = a.claim(); ok || err != nil {
+		t.Fatalf("claimed twice: %v %v", ok, err)
+	}
+
+	// Only the owner of the claim acks it.
+	if err = ackTestRequest(a, id); err != ErrClaimLost {
+		t.Fatalf("ack by a: %v", err)
+	}
+	if err = ackTestRequest(b, id); err != nil {
+		t.Fatalf("ack by b: %v", err)
+	}
+	checkQueueState(t, o.DB(), id, queueDone, "b")
+	req.writeback()
+
+	// The pusher is notified.
+	a.collect()
+	select {
+	case resp := <-respChan:
+		if resp.Err != nil {
+			t.Fatal(resp.Err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("not notified")
+	}
+}
+
+func TestDBQueueLease(t *testing.T) {
+	o, meta, queues := newTestDBQueues(t, "a", "b")
+	a, b := queues[0], queues[1]
+	b.SetLease(time.Minute)
+	id, _ := pushTestRequest(t, a, meta, "u1")
+	if _, ok, err := a.claim(); err != nil || !ok {
+		t.Fatalf("claim: %v %v", ok, err)
+	}
+
+	// The claim within the lease is kept.
+	if err := b.recoverClaims(""); err != nil {
+		t.Fatal(err)
+	}
+	checkQueueState(t, o.DB(), id, queueProcessing, "a")
+
+	// The expired claim is put back and claimed by b, then a loses it.
+	if _, err := o.DB().Exec("UPDATE opay_queue SET updated_at = ? WHERE id = ?", time.Now().Add(-2*time.Minute).Unix(), id); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.recoverClaims(""); err != nil {
+		t.Fatal(err)
+	}
+	checkQueueState(t, o.DB(), id, queuePending, "")
+	if _, ok, err := b.claim(); err != nil || !ok {
+		t.Fatalf("claim by b: %v %v", ok, err)
+	}
+	if err := ackTestRequest(a, id); err != ErrClaimLost {
+		t.Fatalf("ack by a: %v", err)
+	}
+	if err := ackTestRequest(b, id); err != nil {
+		t.Fatalf("ack by b: %v", err)
+	}
+}
+
+func TestDBQueueRecover(t *testing.T) {
+	o, meta, queues := newTestDBQueues(t, "a")
+	id, _ := pushTestRequest(t, queues[0], meta, "u1")
+	if _, ok, err := queues[0].claim(); err != nil || !ok {
+		t.Fatalf("claim: %v %v", ok, err)
+	}
+
+	// Other instances keep the unexpired claim.
+	if _, err := NewDBQueue(o, "opay_queue", testCodec{meta}, "b"); err != nil {
+		t.Fatal(err)
+	}
+	checkQueueState(t, o.DB(), id, queueProcessing, "a")
+
+	// Restarted with the same owner, the claim is put back.
+	restarted, err := NewDBQueue(o, "opay_queue", testCodec{meta}, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkQueueState(t, o.DB(), id, queuePending, "")
+	req, ok, err := restarted.claim()
+	if err != nil || !ok || req.Initiator.GetUid() != "u1" {
+		t.Fatalf("claim: %v %v %+v", ok, err, req)
+	}
+}
+
+func TestDBQueueRemoteError(t *testing.T) {
+	_, meta, queues := newTestDBQueues(t, "a")
+	q := queues[0]
+	for _, c := range []struct {
+		err, want error
+	}{
+		{ErrReprocess.With("opay.remote.detail", "order 1"), ErrReprocess},
+		{errors.New("broker is down"), ErrRemote},
+	} {
+		id, respChan := pushTestRequest(t, q, meta, "u1")
+		// Finished by another instance.
+		if _, err := q.db.Exec("UPDATE opay_queue SET state = ?, owner = ? WHERE id = ?", queueProcessing, q.owner, id); err != nil {
+			t.Fatal(err)
+		}
+		q.finish(id, c.err)
+		q.collect()
+
+		resp := <-respChan
+		e, ok := resp.Err.(*Error)
+		if !ok || !errors.Is(e, c.want) || e.Category != c.want.(*Error).Category || e.Error() != c.err.Error() {
+			t.Fatalf("%v: %#v", c.err, resp.Err)
+		}
+	}
+}
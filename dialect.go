@@ -0,0 +1,127 @@
+package opay
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Dialect is the SQL dialect of the database driver,
+// used by the built-in SQL stores.
+type Dialect int
+
+// Supported SQL dialects
+const (
+	MYSQL Dialect = iota
+	POSTGRES
+	SQLITE
+)
+
+// DialectOf returns the dialect of the driver, MYSQL by default.
+func DialectOf(driverName string) Dialect {
+	switch driverName {
+	case "postgres", "pgx", "pq", "cloudsqlpostgres":
+		return POSTGRES
+	case "sqlite3", "sqlite":
+		return SQLITE
+	}
+	return MYSQL
+}
+
+// AutoIncrementKey returns the column type of an auto increment primary key.
+func (d Dialect) AutoIncrementKey() string {
+	switch d {
+	case POSTGRES:
+		return "BIGSERIAL PRIMARY KEY"
+	case SQLITE:
+		return "INTEGER PRIMARY KEY AUTOINCREMENT"
+	}
+	return "BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY"
+}
+
+// Blob returns the column type of binary data.
+func (d Dialect) Blob() string {
+	switch d {
+	case POSTGRES:
+		return "BYTEA"
+	case SQLITE:
+		return "BLOB"
+	}
+	return "LONGBLOB"
+}
+
+// Decimal returns the column type of exact decimals,
+// SQLite stores them as text to keep the precision.
+func (d Dialect) Decimal() string {
+	if d == SQLITE {
+		return "TEXT"
+	}
+	return "DECIMAL(36,18)"
+}
+
+// ForUpdate returns the row locking clause,
+// which skips the rows locked by others if skipLocked.
+// SQLite locks the whole database, so it is empty.
+func (d Dialect) ForUpdate(skipLocked bool) string {
+	if d == SQLITE {
+		return ""
+	}
+	if skipLocked {
+		return " FOR UPDATE SKIP LOCKED"
+	}
+	return " FOR UPDATE"
+}
+
+// CreateTable returns the statements creating the table and its indexes if not exist,
+// each index is a comma separated column list.
+func (d Dialect) CreateTable(table string, columns []string, indexes ...string) []string {
+	var (
+		defs  = strings.Join(columns, ",\n\t")
+		stmts []string
+	)
+	for i, index := range indexes {
+		name := table + "_idx" + strconv.Itoa(i)
+		if d == MYSQL {
+			defs += ",\n\tKEY " + name + " (" + index + ")"
+			continue
+		}
+		stmts = append(stmts, "CREATE INDEX IF NOT EXISTS "+name+" ON "+table+" ("+index+")")
+	}
+	return append([]string{"CREATE TABLE IF NOT EXISTS " + table + " (\n\t" + defs + "\n)"}, stmts...)
+}
+
+// Exec executes the statements in order.
+func (d Dialect) Exec(e sqlx.Execer, stmts []string) error {
+	for _, stmt := range stmts {
+		if _, err := e.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InsertIgnore rewrites the 'INSERT INTO' statement to ignore the conflicted rows.
+func (d Dialect) InsertIgnore(query string) string {
+	switch d {
+	case POSTGRES:
+		return query + " ON CONFLICT DO NOTHING"
+	case SQLITE:
+		return strings.Replace(query, "INSERT INTO", "INSERT OR IGNORE INTO", 1)
+	}
+	return strings.Replace(query, "INSERT INTO", "INSERT IGNORE INTO", 1)
+}
+
+// InsertId executes the insert statement, and returns the auto increment id column named 'id'.
+func (d Dialect) InsertId(e sqlx.Ext, query string, args ...interface{}) (id int64, err error) {
+	query = e.Rebind(query)
+	if d == POSTGRES {
+		err = e.QueryRowx(query+" RETURNING id", args...).Scan(&id)
+		return
+	}
+	result, err := e.Exec(query, args...)
+	if err != nil {
+		return
+	}
+	return result.LastInsertId()
+}
@@ -9,8 +9,9 @@
 // 2. 实现订单接口
 // 3. 注册订单类型对应的操作接口实例
 // 4. 新建服务实例 var opay=NewOpay(db, 5000)
-// 5. 开启服务协程 go opay.Serve()
+// 5. 开启服务协程 go opay.Serve()，或使用 opay.Start()
 // 6. 推送订单 done, err:=opay.Push(Request{})
 // 7. 使用 <-done 等待订单处理结束
+// 8. 停止服务 opay.Shutdown(ctx)，处理完队列中及正在处理的订单后退出
 
 package opay
@@ -1,34 +1,136 @@
 package opay
 
 import (
-	"errors"
+	"fmt"
 )
 
+type (
+	// Error is the typed error of opay.
+	// The errors of the same Code match each other with errors.Is,
+	// so the sentinels below keep working when the errors carry details or causes.
+	Error struct {
+		Code     int
+		Category Category
+		Key      string        //message key in the catalogs
+		Args     []interface{} //arguments of the message
+		Cause    error         //the optional, wrapped error
+	}
+
+	// Category classifies the errors.
+	Category string
+)
+
+// The error categories.
+const (
+	VALIDATION  Category = "validation"  //the request is invalid
+	NOT_FOUND   Category = "not_found"   //something does not exist
+	CONFLICT    Category = "conflict"    //conflicts with the current state
+	TIMEOUT     Category = "timeout"     //timed out
+	UNAVAILABLE Category = "unavailable" //the service is unavailable now
+	INTERNAL    Category = "internal"    //misconfiguration or bug
+)
+
+// NewError creates a typed error, the codes of the subpackages should not collide.
+func NewError(code int, category Category, key string) *Error {
+	return &Error{
+		Code:     code,
+		Category: category,
+		Key:      key,
+	}
+}
+
+// Error implements error, with the message in the default language.
+func (e *Error) Error() string {
+	msg := e.Message(Lang())
+	if e.Cause != nil {
+		msg += ": " + e.Cause.Error()
+	}
+	return msg
+}
+
+// Message returns the message in the language, without the cause.
+func (e *Error) Message(lang string) string {
+	format := lookup(lang, e.Key)
+	if len(e.Args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, e.Args...)
+}
+
+// Unwrap returns the cause.
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+// Is reports whether target is an *Error of the same code.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// With returns a copy with the detailed message.
+func (e *Error) With(key string, args ...interface{}) *Error {
+	c := *e
+	c.Key, c.Args = key, args
+	return &c
+}
+
+// Wrap returns a copy with the cause.
+func (e *Error) Wrap(cause error) *Error {
+	c := *e
+	c.Cause = cause
+	return &c
+}
+
 var (
-	// ErrTimeout = errors.New("opay: add to queue timeout.")
-	ErrTimeout = errors.New("加入交易队列超时")
-
-	// ErrInvalidStatus       = errors.New("opay: order status is invalid.")
-	ErrInvalidStatus = errors.New("无效的交易订单状态")
-	// ErrStakeholderNotExist = errors.New("opay: stakeholder order is not exist.")
-	ErrStakeholderNotExist = errors.New("关联订单不存在")
-	// ErrExtraStakeholder    = errors.New("opay: stakeholder order is extra.")
-	ErrExtraStakeholder = errors.New("多余的关联订单")
-	// ErrIncorrectAmount     = errors.New("opay: account operation amount is incorrect.")
-	ErrIncorrectAmount = errors.New("交易金额不正确")
-	// ErrInitiatorNil        = errors.New("opay: request.Initiator can not be nil.")
-	ErrInitiatorNil = errors.New("交易订单为空")
-
-	// ErrIllegalStep       = errors.New("opay: illegal step.")
-	ErrIllegalStep = errors.New("非法的交易订单操作")
-	// ErrInvalidStep  = errors.New("opay: invalid operation.")
-	ErrInvalidStep = errors.New("无效的交易订单操作")
-	// ErrCancelStep        = errors.New("opay: the order cannot be canceled.")
-	ErrCancelStep = errors.New("交易订单不可撤销")
-	// ErrReprocess         = errors.New("opay: repeat process order.")
-	ErrReprocess = errors.New("重复操作交易订单")
-	// ErrDifferentStep     = errors.New("opay: initiator's step and stakeholder's must be same.")
-	ErrDifferentStep = errors.New("关联订单的操作不一致")
-	// ErrDifferentOperator = errors.New("opay: initiator's type and stakeholder's must be same.")
-	ErrDifferentType = errors.New("关联订单的类型不一致")
+	ErrTimeout      = NewError(1001, TIMEOUT, "opay.timeout")
+	ErrQueueClosed  = NewError(1002, UNAVAILABLE, "opay.queue_closed")
+	ErrStarted      = NewError(1003, CONFLICT, "opay.started")
+	ErrNotStarted   = NewError(1004, UNAVAILABLE, "opay.not_started")
+	ErrQueueOwner   = NewError(1005, INTERNAL, "opay.queue_owner")
+	ErrQueueCodec   = NewError(1006, INTERNAL, "opay.queue_codec")
+	ErrQueueOwnerId = NewError(1007, INTERNAL, "opay.queue_owner_id")
+	ErrQueueTx      = NewError(1008, VALIDATION, "opay.queue_tx")
+	ErrClaimLost    = NewError(1009, CONFLICT, "opay.claim_lost")
+	ErrRemote       = NewError(1010, INTERNAL, "opay.remote")
+	ErrPanic        = NewError(1011, INTERNAL, "opay.panic")
+
+	ErrUnknownMeta       = NewError(1101, NOT_FOUND, "opay.unknown_meta")
+	ErrMetaRegistered    = NewError(1102, CONFLICT, "opay.meta_registered")
+	ErrInvalidHandler    = NewError(1103, INTERNAL, "opay.invalid_handler")
+	ErrUnknownStep       = NewError(1104, INTERNAL, "opay.unknown_step")
+	ErrUnknownStatus     = NewError(1105, NOT_FOUND, "opay.unknown_status")
+	ErrSettleNotFound    = NewError(1106, NOT_FOUND, "opay.settle_not_found")
+	ErrSettleRegistered  = NewError(1107, CONFLICT, "opay.settle_registered")
+	ErrFreezerNotFound   = NewError(1108, NOT_FOUND, "opay.freezer_not_found")
+	ErrFreezerRegistered = NewError(1109, CONFLICT, "opay.freezer_registered")
+	ErrEmptySettle       = NewError(1110, INTERNAL, "opay.empty_settle")
+	ErrFeeAccount        = NewError(1111, INTERNAL, "opay.fee_account")
+
+	ErrInvalidStatus       = NewError(1201, VALIDATION, "opay.invalid_status")
+	ErrStakeholderNotExist = NewError(1202, VALIDATION, "opay.stakeholder_not_exist")
+	ErrExtraStakeholder    = NewError(1203, VALIDATION, "opay.extra_stakeholder")
+	ErrPartyNil            = NewError(1204, VALIDATION, "opay.party_nil")
+	ErrNotZeroSum          = NewError(1205, VALIDATION, "opay.not_zero_sum")
+	ErrIncorrectAmount     = NewError(1206, VALIDATION, "opay.incorrect_amount")
+	ErrInvalidAmount       = NewError(1207, VALIDATION, "opay.invalid_amount")
+	ErrAmountOverflow      = NewError(1208, VALIDATION, "opay.amount_overflow")
+	ErrAmountScale         = NewError(1209, VALIDATION, "opay.amount_scale")
+	ErrQuoteNotFound       = NewError(1210, NOT_FOUND, "opay.quote_not_found")
+	ErrQuoteExpired        = NewError(1211, VALIDATION, "opay.quote_expired")
+	ErrQuoteMismatch       = NewError(1212, VALIDATION, "opay.quote_mismatch")
+	ErrInitiatorNil        = NewError(1213, VALIDATION, "opay.initiator_nil")
+	ErrDifferentStep       = NewError(1214, VALIDATION, "opay.different_step")
+	ErrDifferentType       = NewError(1215, VALIDATION, "opay.different_type")
+	ErrExtraParty          = NewError(1216, VALIDATION, "opay.extra_party")
+	ErrQuoteConsumed       = NewError(1217, CONFLICT, "opay.quote_consumed")
+
+	ErrIllegalStep         = NewError(1301, VALIDATION, "opay.illegal_step")
+	ErrInvalidStep         = NewError(1302, CONFLICT, "opay.invalid_step")
+	ErrInvalidTransition   = NewError(1303, CONFLICT, "opay.invalid_transition")
+	ErrCancelStep          = NewError(1304, CONFLICT, "opay.cancel_step")
+	ErrReprocess           = NewError(1305, CONFLICT, "opay.reprocess")
+	ErrNotRefundable       = NewError(1306, VALIDATION, "opay.not_refundable")
+	ErrRefundExceeded      = NewError(1307, VALIDATION, "opay.refund_exceeded")
+	ErrIdempotencyConflict = NewError(1308, CONFLICT, "opay.idempotency_conflict")
 )
@@ -0,0 +1,56 @@
+package opay
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestErrorIs(t *testing.T) {
+	err := ErrInvalidTransition.With("opay.invalid_transition.detail", "test", "1(pend, PEND)", "3(cancel, CANCEL)")
+	if !errors.Is(err, ErrInvalidTransition) || errors.Is(err, ErrInvalidStep) {
+		t.Fatalf("errors.Is: %v", err)
+	}
+	var e *Error
+	if !errors.As(fmt.Errorf("wrapped: %w", err), &e) || e.Category != CONFLICT || e.Code != ErrInvalidTransition.Code {
+		t.Fatalf("errors.As: %#v", e)
+	}
+
+	cause := errors.New("connection reset")
+	wrapped := ErrRemote.Wrap(cause)
+	if !errors.Is(wrapped, ErrRemote) || !errors.Is(wrapped, cause) {
+		t.Fatalf("wrap: %v", wrapped)
+	}
+
+	if _, err := ParseAmount("1.2.3"); !errors.Is(err, ErrInvalidAmount) {
+		t.Fatalf("parse: %v", err)
+	}
+}
+
+func TestLocalize(t *testing.T) {
+	if got := ErrTimeout.Error(); got != "加入交易队列超时" {
+		t.Fatalf("default: %q", got)
+	}
+	if got := Localize(ErrTimeout, EN); got != "opay: add to queue timeout." {
+		t.Fatalf("en: %q", got)
+	}
+	err := fmt.Errorf("do: %w", ErrSettleNotFound.With("opay.settle_not_found.detail", "9"))
+	if got := Localize(err, EN); got != "do: opay: not found SettleFunc '9'." {
+		t.Fatalf("wrapped: %q", got)
+	}
+	if got := Localize(ErrRemote.Wrap(ErrTimeout), EN); got != "opay: failed to handle the request.: opay: add to queue timeout." {
+		t.Fatalf("cause: %q", got)
+	}
+
+	// Falls back to the default language.
+	RegCatalog("fr", Catalog{"opay.timeout": "opay : délai d'attente dépassé."})
+	if got := Localize(ErrTimeout, "fr"); got != "opay : délai d'attente dépassé." {
+		t.Fatalf("fr: %q", got)
+	}
+	if got := Localize(ErrQueueClosed, "fr"); got != ErrQueueClosed.Error() {
+		t.Fatalf("fallback: %q", got)
+	}
+	if got := Localize(errors.New("plain"), EN); got != "plain" {
+		t.Fatalf("plain: %q", got)
+	}
+}
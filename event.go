@@ -0,0 +1,130 @@
+package opay
+
+import (
+	"runtime/debug"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type (
+	// Event is emitted by the core or the handlers,
+	// and delivered to the subscribers only after the transaction is committed.
+	Event struct {
+		Name      string //EVENT_STATUS_CHANGED, or the custom name emitted by the handler
+		OrderType string
+		OrderId   string
+		From      int64 //the previous status code
+		To        int64 //the target status code
+		Step      Step
+		Uid       string
+		Aid       string
+		Amount    Amount
+		Data      interface{} //the optional, payload of the custom event
+	}
+
+	// EventHandler handles the events.
+	EventHandler func(Event)
+
+	// EventWriter writes the events in the request's transaction before committing,
+	// so they are exactly as durable as the orders, such as a transactional outbox.
+	EventWriter interface {
+		WriteEvents(tx *sqlx.Tx, events []Event) error
+	}
+
+	// Subscribers of the events.
+	eventBus struct {
+		sync  []EventHandler
+		async []EventHandler
+		mu    sync.RWMutex
+		wg    sync.WaitGroup //in-flight asynchronous deliveries
+	}
+)
+
+const (
+	EVENT_STATUS_CHANGED = "status_changed" // EVENT_STATUS_CHANGED is the name of the events emitted by the core for each order
+)
+
+// Subscribe adds the handler called synchronously in order after committing,
+// before the response is written back.
+func (opay *Opay) Subscribe(handler EventHandler) {
+	opay.events.mu.Lock()
+	opay.events.sync = append(opay.events.sync, handler)
+	opay.events.mu.Unlock()
+}
+
+// SubscribeAsync adds the handler called in a new goroutine for each event after committing,
+// the events are not ordered. Shutdown waits for the calls.
+func (opay *Opay) SubscribeAsync(handler EventHandler) {
+	opay.events.mu.Lock()
+	opay.events.async = append(opay.events.async, handler)
+	opay.events.mu.Unlock()
+}
+
+// SetEventWriter sets the writer of the events in the request's transaction,
+// it must be called before starting.
+func (opay *Opay) SetEventWriter(writer EventWriter) error {
+	opay.stateMu.Lock()
+	defer opay.stateMu.Unlock()
+	if opay.started {
+		return ErrStarted
+	}
+	opay.eventWriter = writer
+	return nil
+}
+
+// Publish delivers the events to the subscribers.
+// It is called automatically for the requests whose transactions are owned by opay,
+// otherwise the caller must publish Response.Events after committing Request.Tx,
+// since opay does not know when or whether it is committed.
+func (opay *Opay) Publish(events ...Event) {
+	opay.events.mu.RLock()
+	syncHandlers, asyncHandlers := opay.events.sync, opay.events.async
+	opay.events.mu.RUnlock()
+	for _, event := range events {
+		for _, handler := range syncHandlers {
+			opay.deliver(handler, event)
+		}
+		for _, handler := range asyncHandlers {
+			opay.events.wg.Add(1)
+			go func(handler EventHandler, event Event) {
+				defer opay.events.wg.Done()
+				opay.deliver(handler, event)
+			}(handler, event)
+		}
+	}
+}
+
+// Calls the handler, recovers the panic.
+func (opay *Opay) deliver(handler EventHandler, event Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			opay.logger.Printf("opay: event handler panic: %v\n%s", r, debug.Stack())
+		}
+	}()
+	handler(event)
+}
+
+// Emit emits the event, which is delivered after the transaction is committed,
+// and discarded if it is rolled back.
+func (ctx *Context) Emit(event Event) {
+	ctx.Response.emit(event)
+}
+
+// Emits the status change events of the orders.
+func (ctx *Context) emitStatusChanges() {
+	for _, order := range ctx.Orders() {
+		status, _ := order.GetMeta().Status(order.TargetStatus())
+		ctx.Emit(Event{
+			Name:      EVENT_STATUS_CHANGED,
+			OrderType: ctx.Request.Operator(),
+			OrderId:   OrderId(order),
+			From:      order.PreStatus(),
+			To:        order.TargetStatus(),
+			Step:      status.Step,
+			Uid:       order.GetUid(),
+			Aid:       order.GetAid(),
+			Amount:    order.GetAmount(),
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package opay
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestEventPublish(t *testing.T) {
+	o, meta := newTestOpay(t, 1)
+	var (
+		syncEvents []Event
+		wg         sync.WaitGroup
+	)
+	o.Subscribe(func(e Event) { syncEvents = append(syncEvents, e) })
+	o.Subscribe(func(Event) { panic("recovered") })
+	wg.Add(2)
+	o.SubscribeAsync(func(Event) { wg.Done() })
+
+	req := newTestRequest(meta, "a", 1)
+	if _, err := req.prepare(o); err != nil {
+		t.Fatal(err)
+	}
+	ctx := &Context{opay: o, Request: req, Response: req.response}
+	ctx.emitStatusChanges()
+	ctx.Emit(Event{Name: "custom"})
+	o.Publish(ctx.Response.Events...)
+	wg.Wait()
+
+	if len(syncEvents) != 2 {
+		t.Fatalf("events: %+v", syncEvents)
+	}
+	e := syncEvents[0]
+	if e.Name != EVENT_STATUS_CHANGED || e.From != meta.UnsetCode() || e.To != 1 || e.Step != PEND || e.Uid != "a" {
+		t.Fatalf("status change event: %+v", e)
+	}
+	if req.response.takeEvents(); len(req.response.Events) != 0 {
+		t.Fatal("events are not cleared")
+	}
+}
+
+func TestEventCallerTx(t *testing.T) {
+	o := New(newTestDB(t), WithSettleFuncMap(newTestSettles()))
+	meta, err := o.RegMeta("test", HandlerFunc(func(ctx *Context) error {
+		ctx.Emit(Event{Name: "custom"})
+		if ctx.Request.Initiator.GetUid() == "bad" {
+			return ErrIncorrectAmount
+		}
+		return nil
+	}), []Status{
+		{Code: 1, Note: "pend", Step: PEND},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var published []Event
+	o.Subscribe(func(e Event) { published = append(published, e) })
+	startTestOpay(t, o)
+
+	tx, err := o.DB().Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	do := func(uid string) *Response {
+		req := newTestRequest(meta, uid, 1)
+		req.Tx = tx
+		return o.Do(req)
+	}
+	if resp := do("bad"); resp.Err != ErrIncorrectAmount || len(resp.Events) != 0 {
+		t.Fatalf("failed: %v %+v", resp.Err, resp.Events)
+	}
+	resp := do("a")
+	if resp.Err != nil || len(resp.Events) != 2 {
+		t.Fatalf("succeeded: %v %+v", resp.Err, resp.Events)
+	}
+	// The events wait for the caller to commit, but the request is finished.
+	if len(published) != 0 {
+		t.Fatalf("published before committing: %+v", published)
+	}
+	if stats := o.Stats(); stats.Commits != 1 || stats.Rollbacks != 1 {
+		t.Fatalf("commits: %d, rollbacks: %d", stats.Commits, stats.Rollbacks)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	o.Publish(resp.Events...)
+	if len(published) != 2 {
+		t.Fatalf("published: %+v", published)
+	}
+}
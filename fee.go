@@ -0,0 +1,214 @@
+package opay
+
+import (
+	"sync"
+)
+
+type (
+	// FeeRule calculates the fee of an amount, both are non-negative.
+	FeeRule interface {
+		Fee(amount Amount) Amount
+	}
+
+	// FixedFee charges a fixed fee.
+	FixedFee struct {
+		Amount Amount
+	}
+
+	// PercentFee charges Rate of the amount, e.g. 0.006 for 0.6%,
+	// limited between Min and Max, no limit if they are zero.
+	PercentFee struct {
+		Rate Amount
+		Min  Amount
+		Max  Amount
+	}
+
+	// TieredFee applies the rule of the first tier whose UpTo is not less than the amount,
+	// the UpTo of the last tier can be zero for no limit.
+	TieredFee []FeeTier
+
+	// FeeTier is a tier of TieredFee.
+	FeeTier struct {
+		UpTo Amount
+		Rule FeeRule
+	}
+
+	// FeeEngine calculates the fee of the orders.
+	FeeEngine interface {
+		// Fee returns the fee of the order type and asset for the amount, which is non-negative.
+		Fee(orderType, aid string, amount Amount) (Amount, error)
+	}
+
+	// FeeSchedule is a FeeEngine of the rules per order type and asset.
+	FeeSchedule struct {
+		rules map[string]FeeRule
+		mu    sync.RWMutex
+	}
+
+	// FeeOrder is an order recording its fee, such as base.BaseOrder.
+	FeeOrder interface {
+		GetFee() Amount
+		SetFee(fee Amount)
+	}
+)
+
+var (
+	_ FeeRule   = FixedFee{}
+	_ FeeRule   = PercentFee{}
+	_ FeeRule   = TieredFee{}
+	_ FeeEngine = (*FeeSchedule)(nil)
+)
+
+// Fee implements FeeRule.
+func (f FixedFee) Fee(Amount) Amount {
+	return f.Amount
+}
+
+// Fee implements FeeRule.
+func (f PercentFee) Fee(amount Amount) Amount {
+	scale := amount.Scale() + f.Rate.Scale()
+	if scale > MAX_AMOUNT_SCALE {
+		scale = MAX_AMOUNT_SCALE
+	}
+	fee := amount.Mul(f.Rate, scale)
+	if !f.Min.IsZero() && fee.Cmp(f.Min) < 0 {
+		fee = f.Min
+	}
+	if !f.Max.IsZero() && fee.Cmp(f.Max) > 0 {
+		fee = f.Max
+	}
+	return fee
+}
+
+// Fee implements FeeRule, it is zero if no tier matches.
+func (f TieredFee) Fee(amount Amount) Amount {
+	for _, tier := range f {
+		if tier.UpTo.IsZero() || amount.Cmp(tier.UpTo) <= 0 {
+			return tier.Rule.Fee(amount)
+		}
+	}
+	return Amount{}
+}
+
+// NewFeeSchedule creates an empty FeeSchedule, which charges nothing.
+func NewFeeSchedule() *FeeSchedule {
+	return &FeeSchedule{
+		rules: make(map[string]FeeRule),
+	}
+}
+
+// SetRule sets the rule of the order type and asset, the empty one matches any.
+// The more specific rule takes precedence, and the order type is prior to the asset.
+func (s *FeeSchedule) SetRule(orderType, aid string, rule FeeRule) {
+	s.mu.Lock()
+	s.rules[orderType+"\x00"+aid] = rule
+	s.mu.Unlock()
+}
+
+// Fee implements FeeEngine.
+func (s *FeeSchedule) Fee(orderType, aid string, amount Amount) (Amount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, key := range []string{
+		orderType + "\x00" + aid,
+		orderType + "\x00",
+		"\x00" + aid,
+		"\x00",
+	} {
+		if rule, ok := s.rules[key]; ok {
+			return rule.Fee(amount), nil
+		}
+	}
+	return Amount{}, nil
+}
+
+// SetFeeEngine sets the fee engine, and the uid of the fee accounts credited with the fees,
+// it must be called before starting.
+func (opay *Opay) SetFeeEngine(engine FeeEngine, uid string) error {
+	opay.stateMu.Lock()
+	defer opay.stateMu.Unlock()
+	if opay.started {
+		return ErrStarted
+	}
+	opay.fees, opay.feeUid = engine, uid
+	return nil
+}
+
+// QuoteFee returns the fee of the order type and asset for the amount,
+// rounded to the number of decimal places, which is zero if no fee engine.
+func (opay *Opay) QuoteFee(orderType, aid string, amount Amount) (Amount, error) {
+	if opay.fees == nil {
+		return Amount{}, nil
+	}
+	fee, err := opay.fees.Fee(orderType, aid, amount.Abs())
+	if err != nil {
+		return fee, err
+	}
+	if fee.Sign() < 0 {
+		return fee, ErrIncorrectAmount
+	}
+	return fee.Round(opay.NumOfDecimalPlaces()), nil
+}
+
+// Fee returns the fee of the initiator order for the base amount.
+// If the initiator is a FeeOrder, the fee is recorded on it when the order is created,
+// and the recorded one is returned in the later steps.
+func (ctx *Context) Fee(base Amount) (Amount, error) {
+	initiator := ctx.Request.Initiator
+	order, ok := initiator.(FeeOrder)
+	if ok {
+		pre, _ := initiator.GetMeta().Status(initiator.PreStatus())
+		if pre.Step != UNSET {
+			ctx.fee = order.GetFee()
+			return ctx.fee, nil
+		}
+	}
+	fee, err := ctx.opay.QuoteFee(ctx.Request.Operator(), initiator.GetAid(), base)
+	if err != nil {
+		return fee, err
+	}
+	if ok {
+		order.SetFee(fee)
+	}
+	ctx.fee = fee
+	return fee, nil
+}
+
+// Reports whether the sum of the amounts of each asset is 0,
+// including the fee credited to the fee account of the initiator's asset.
+func (ctx *Context) zeroSum() (bool, error) {
+	return zeroSum(ctx.Request.orders(), map[string]Amount{
+		ctx.Request.Initiator.GetAid(): ctx.fee,
+	})
+}
+
+// Credits the fee returned by Fee to the fee account of the initiator's asset,
+// or debits it if rollback, and returns the change to post with the orders' changes,
+// which is nil if no fee.
+func (ctx *Context) settleFee(rollback bool) (*BalanceChange, error) {
+	fee := ctx.fee
+	if fee.IsZero() {
+		return nil, nil
+	}
+	if len(ctx.opay.feeUid) == 0 {
+		return nil, ErrFeeAccount
+	}
+	if rollback {
+		fee = fee.Neg()
+	}
+	initiator := ctx.Request.Initiator
+	settle, err := ctx.opay.GetSettleFunc(initiator.GetAid())
+	if err != nil {
+		return nil, err
+	}
+	err = settle(ctx.opay.feeUid, fee, ctx.Request.Tx)
+	if err != nil {
+		return nil, err
+	}
+	return &BalanceChange{
+		OrderId: OrderId(initiator),
+		Uid:     ctx.opay.feeUid,
+		Aid:     initiator.GetAid(),
+		Amount:  fee,
+	}, nil
+}
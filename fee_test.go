@@ -0,0 +1,102 @@
+package opay
+
+import (
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestFeeSchedule(t *testing.T) {
+	s := NewFeeSchedule()
+	s.SetRule("", "", FixedFee{MustParseAmount("1")})
+	s.SetRule("withdraw", "", PercentFee{
+		Rate: MustParseAmount("0.006"),
+		Min:  MustParseAmount("2"),
+		Max:  MustParseAmount("25"),
+	})
+	s.SetRule("withdraw", "2", TieredFee{
+		{UpTo: MustParseAmount("1000"), Rule: FixedFee{MustParseAmount("5")}},
+		{Rule: PercentFee{Rate: MustParseAmount("0.001")}},
+	})
+
+	o := NewOpay(nil, 1, 2)
+	if err := o.SetFeeEngine(s, "fee"); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		orderType, aid, amount, fee string
+	}{
+		{"transfer", "1", "100", "1"},
+		{"withdraw", "1", "100", "2"},        //min
+		{"withdraw", "1", "1234.56", "7.41"}, //rounded
+		{"withdraw", "1", "10000", "25"},     //max
+		{"withdraw", "2", "-1000", "5"},
+		{"withdraw", "2", "12345", "12.35"},
+	} {
+		fee, err := o.QuoteFee(c.orderType, c.aid, MustParseAmount(c.amount))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !fee.Equal(MustParseAmount(c.fee)) {
+			t.Errorf("%s %s %s: got %s, want %s", c.orderType, c.aid, c.amount, fee, c.fee)
+		}
+	}
+}
+
+func TestFeeZeroSum(t *testing.T) {
+	o := New(newTestDB(t), WithSettleFuncMap(newTestSettles()))
+	s := NewFeeSchedule()
+	s.SetRule("", "", FixedFee{MustParseAmount("0.1")})
+	if err := o.SetFeeEngine(s, "fee"); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := o.RegMeta("transfer", HandlerFunc(func(ctx *Context) error {
+		if _, err := ctx.Fee(ctx.Request.Stakeholder.GetAmount()); err != nil {
+			return err
+		}
+		if err := ctx.UpdateBalance(); err != nil {
+			return err
+		}
+		return ctx.SyncDeal()
+	}), []Status{
+		{Code: 1, Note: "transferred", Step: SYNC_DEAL},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta.SetZeroSum(true)
+	var postings []*Posting
+	if err = o.SetJournal(journalFunc(func(_ *sqlx.Tx, posting *Posting) error {
+		postings = append(postings, posting)
+		return nil
+	})); err != nil {
+		t.Fatal(err)
+	}
+	startTestOpay(t, o)
+
+	transfer := func(paid, received string) error {
+		return o.Do(Request{
+			Initiator:   &testOrder{meta: meta, pre: meta.UnsetCode(), target: 1, uid: "u1", aid: "1", amount: MustParseAmount(paid)},
+			Stakeholder: &testOrder{meta: meta, pre: meta.UnsetCode(), target: 1, uid: "u2", aid: "1", amount: MustParseAmount(received)},
+		}).Err
+	}
+	if err = transfer("-10.1", "10"); err != nil {
+		t.Fatalf("with fee: %v", err)
+	}
+	// The fee is posted with the orders' changes, so the posting is balanced.
+	if len(postings) != 1 || len(postings[0].Changes) != 3 ||
+		postings[0].Changes[2].Uid != "fee" || !postings[0].Changes[2].Amount.Equal(MustParseAmount("0.1")) {
+		t.Fatalf("postings: %+v", postings)
+	}
+	for _, paid := range []string{"-10", "-10.2"} {
+		if err = transfer(paid, "10"); err != ErrNotZeroSum {
+			t.Fatalf("%s: %v", paid, err)
+		}
+	}
+}
+
+type journalFunc func(tx *sqlx.Tx, posting *Posting) error
+
+func (f journalFunc) Post(tx *sqlx.Tx, posting *Posting) error {
+	return f(tx, posting)
+}
@@ -0,0 +1,66 @@
+package opay
+
+import (
+	"github.com/jmoiron/sqlx"
+)
+
+// The bridge for the legacy implementations with float64 amounts.
+
+type (
+	// FloatOrder is the legacy operation interface of order, whose amount is float64.
+	FloatOrder interface {
+		GetMeta() *Meta
+		PreStatus() int64
+		TargetStatus() int64
+		GetUid() string
+		GetAid() string
+		GetAmount() float64
+		Pend(*sqlx.Tx, KV) error
+		Do(*sqlx.Tx, KV) error
+		Succeed(*sqlx.Tx, KV) error
+		Cancel(*sqlx.Tx, KV) error
+		Fail(*sqlx.Tx, KV) error
+		SyncDeal(*sqlx.Tx, KV) error
+	}
+
+	// FloatSettleFunc is the legacy account balance operation function, whose amount is float64.
+	FloatSettleFunc func(uid string, amount float64, tx *sqlx.Tx) error
+
+	floatOrder struct {
+		FloatOrder
+		scale int
+	}
+)
+
+// WrapFloatOrder adapts the legacy order to IOrder,
+// its amount is rounded to scale decimal places.
+func WrapFloatOrder(order FloatOrder, scale int) IOrder {
+	checkScale(scale)
+	return &floatOrder{
+		FloatOrder: order,
+		scale:      scale,
+	}
+}
+
+// UnwrapFloatOrder returns the legacy order wrapped by WrapFloatOrder.
+func UnwrapFloatOrder(order IOrder) (FloatOrder, bool) {
+	o, ok := order.(*floatOrder)
+	if !ok {
+		return nil, false
+	}
+	return o.FloatOrder, true
+}
+
+// GetAmount implements IOrder.
+// It is zero if the legacy amount can not be converted, which is rejected as ErrIncorrectAmount.
+func (o *floatOrder) GetAmount() Amount {
+	a, _ := AmountFromFloat(o.FloatOrder.GetAmount(), o.scale)
+	return a
+}
+
+// SettleFunc adapts the legacy function to SettleFunc.
+func (fn FloatSettleFunc) SettleFunc() SettleFunc {
+	return func(uid string, amount Amount, tx *sqlx.Tx) error {
+		return fn(uid, amount.Float64(), tx)
+	}
+}
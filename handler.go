@@ -8,6 +8,10 @@ type (
 
 	// HandlerFunc Order processing interface function
 	HandlerFunc func(*Context) error
+
+	// Middleware wraps the Handler, running in the transaction,
+	// such as logging, auth checks and request validation.
+	Middleware func(next Handler) Handler
 )
 
 var _ Handler = HandlerFunc(nil)
@@ -16,3 +20,13 @@ var _ Handler = HandlerFunc(nil)
 func (hf HandlerFunc) ServeOpay(ctx *Context) error {
 	return hf(ctx)
 }
+
+// Wraps the handler with the middlewares, the first one is the outermost.
+func chain(handler Handler, middlewares ...[]Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		for j := len(middlewares[i]) - 1; j >= 0; j-- {
+			handler = middlewares[i][j](handler)
+		}
+	}
+	return handler
+}
@@ -0,0 +1,47 @@
+package opay
+
+import (
+	"strings"
+	"testing"
+)
+
+type testHandler struct {
+	served bool
+}
+
+func (h *testHandler) ServeOpay(ctx *Context) error {
+	if h.served {
+		panic("the handler instance is reused")
+	}
+	h.served = true
+	ctx.Set("trace", ctx.Get("trace").(string)+"handler,")
+	return nil
+}
+
+func TestMiddleware(t *testing.T) {
+	o := NewOpay(nil, 1, 2)
+	meta, err := o.RegMeta("test", new(testHandler), []Status{{Code: 1, Step: PEND}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	trace := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return HandlerFunc(func(ctx *Context) error {
+				ctx.Set("trace", ctx.Get("trace").(string)+name+",")
+				return next.ServeOpay(ctx)
+			})
+		}
+	}
+	o.Use(trace("global1"), trace("global2"))
+	meta.Use(trace("meta"))
+
+	for i := 0; i < 2; i++ {
+		ctx := &Context{Request: Request{Addition: map[string]interface{}{"trace": ""}}}
+		if err = meta.serve(ctx, o.middlewares); err != nil {
+			t.Fatal(err)
+		}
+		if got := ctx.Get("trace").(string); got != "global1,global2,meta,handler," {
+			t.Fatalf("trace: %s", strings.TrimSuffix(got, ","))
+		}
+	}
+}
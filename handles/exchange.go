@@ -14,15 +14,24 @@ type Exchange struct {
 // 编译期检查接口实现
 var _ Handler = (*Exchange)(nil)
 
-// 执行入口
+// 执行入口，
+// 若设置了opay.QuoteBook，创建订单时检查兑换金额与Request.Addition[opay.QUOTE_ID_KEY]指定的报价是否相符，
+// 报价须属于Initiator的用户，且在事务中标记为已使用
 func (e *Exchange) ServeOpay(ctx *opay.Context) error {
 	if !ctx.HasStakeholder() {
 		return opay.ErrStakeholderNotExist
 	}
-	if ctx.GreaterOrEqual(ctx.Request.Initiator.GetAmount(), 0) ||
-		ctx.SmallerOrEqual(ctx.Request.Stakeholder.GetAmount(), 0) {
+	if ctx.HasParties() {
+		return opay.ErrExtraParty
+	}
+	if ctx.Request.Initiator.GetAmount().Sign() >= 0 ||
+		ctx.Request.Stakeholder.GetAmount().Sign() <= 0 {
 		return opay.ErrIncorrectAmount
 	}
+	err := ctx.CheckQuote()
+	if err != nil {
+		return err
+	}
 	return e.Call(e, ctx)
 }
 
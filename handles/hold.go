@@ -0,0 +1,104 @@
+package handles
+
+import (
+	"github.com/henrylee2cn/opay"
+)
+
+type (
+	/*
+	 * 预授权
+	 * 等待处理时冻结付款账户的资金，成功时从冻结资金中扣除全部或部分金额，
+	 * 撤销或失败时解冻剩余资金。
+	 * Request.Initiator为付款订单（金额为负），需注册对应资产的opay.Freezer；
+	 * Request.Stakeholder可选，为收款订单，金额与Initiator相反。
+	 */
+	Hold struct {
+		Background
+	}
+
+	// 预授权订单接口，可由Request.Initiator实现以支持部分扣款
+	Held interface {
+		// 返回预授权冻结的金额（负数），
+		// 此时GetAmount()返回实际扣款金额，其绝对值不可超过冻结金额
+		HeldAmount() opay.Amount
+	}
+)
+
+// 编译期检查接口实现
+var _ Handler = (*Hold)(nil)
+
+// 执行入口
+func (h *Hold) ServeOpay(ctx *opay.Context) error {
+	if ctx.HasParties() {
+		return opay.ErrExtraParty
+	}
+	amount := ctx.Request.Initiator.GetAmount()
+	held := heldAmount(ctx.Request.Initiator)
+	if amount.Sign() >= 0 || held.Sign() >= 0 || amount.Cmp(held) < 0 {
+		return opay.ErrIncorrectAmount
+	}
+	if ctx.HasStakeholder() &&
+		!ctx.Request.Stakeholder.GetAmount().Equal(amount.Neg()) {
+		return opay.ErrIncorrectAmount
+	}
+	return h.Call(h, ctx)
+}
+
+// 新建订单并冻结资金，标记为等待处理状态
+func (h *Hold) Pend() error {
+	// 冻结资金
+	err := h.Background.Context.FreezeBalance(heldAmount(h.Request.Initiator).Neg())
+	if err != nil {
+		return err
+	}
+
+	// 创建订单
+	return h.Background.Context.Pend()
+}
+
+// 从冻结资金中扣款，解冻剩余资金，并标记订单为成功状态
+func (h *Hold) Succeed() error {
+	// 扣款
+	err := h.Background.Context.CaptureBalance()
+	if err != nil {
+		return err
+	}
+
+	// 解冻剩余资金
+	rest := h.Request.Initiator.GetAmount().Sub(heldAmount(h.Request.Initiator))
+	if rest.Sign() > 0 {
+		err = h.Background.Context.UnfreezeBalance(rest)
+		if err != nil {
+			return err
+		}
+	}
+
+	// 更新订单
+	return h.Background.Context.Succeed()
+}
+
+// 解冻资金，并标记订单为撤销状态
+func (h *Hold) Cancel() error {
+	err := h.Background.Context.UnfreezeBalance(heldAmount(h.Request.Initiator).Neg())
+	if err != nil {
+		return err
+	}
+	return h.Background.Context.Cancel()
+}
+
+// 解冻资金，并标记订单为失败状态
+func (h *Hold) Fail() error {
+	err := h.Background.Context.UnfreezeBalance(heldAmount(h.Request.Initiator).Neg())
+	if err != nil {
+		return err
+	}
+	return h.Background.Context.Fail()
+}
+
+// 返回冻结金额，未实现Held接口时为订单金额
+func heldAmount(order opay.IOrder) opay.Amount {
+	if held, ok := order.(Held); ok {
+		return held.HeldAmount()
+	}
+	return order.GetAmount()
+}
@@ -0,0 +1,103 @@
+package handles
+
+import (
+	"context"
+	"testing"
+
+	"github.com/henrylee2cn/opay"
+	"github.com/henrylee2cn/opay/account"
+	"github.com/jmoiron/sqlx"
+)
+
+// The hold order capturing part of the held amount.
+type testHeld struct {
+	testOrder
+	held opay.Amount
+}
+
+func (o *testHeld) HeldAmount() opay.Amount { return o.held }
+
+func TestHold(t *testing.T) {
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	store := account.New(db, "opay_account")
+	if err = store.CreateTable(); err != nil {
+		t.Fatal(err)
+	}
+	settles := opay.NewSettleFuncMap()
+	if err = store.Register(settles, "1"); err != nil {
+		t.Fatal(err)
+	}
+	o := opay.New(db, opay.WithSettleFuncMap(settles))
+	meta, err := o.RegMeta("hold", &Hold{}, []opay.Status{
+		{Code: 1, Note: "held", Step: opay.PEND},
+		{Code: 2, Note: "captured", Step: opay.SUCCEED},
+		{Code: 3, Note: "canceled", Step: opay.CANCEL},
+		{Code: 4, Note: "failed", Step: opay.FAIL},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = o.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer o.Shutdown(context.Background())
+
+	tx, err := db.Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Settle(tx, "u1", "1", opay.MustParseAmount("100")); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	hold := func(pre, target int64, amount, held string, payee bool) {
+		t.Helper()
+		req := opay.Request{
+			Initiator: &testHeld{
+				testOrder: testOrder{meta: meta, pre: pre, target: target, uid: "u1", aid: "1", amount: opay.MustParseAmount(amount)},
+				held:      opay.MustParseAmount(held),
+			},
+		}
+		if payee {
+			req.Stakeholder = &testOrder{meta: meta, pre: pre, target: target, uid: "m1", aid: "1", amount: opay.MustParseAmount(amount).Neg()}
+		}
+		if err := o.Do(req).Err; err != nil {
+			t.Fatalf("%d -> %d: %v", pre, target, err)
+		}
+	}
+	check := func(uid, balance, frozen string) {
+		t.Helper()
+		a, err := store.Get(uid, "1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !a.Balance.Equal(opay.MustParseAmount(balance)) || !a.Frozen.Equal(opay.MustParseAmount(frozen)) {
+			t.Fatalf("%s: balance %s, frozen %s, want %s, %s", uid, a.Balance, a.Frozen, balance, frozen)
+		}
+	}
+
+	// PEND freezes the held amount.
+	hold(meta.UnsetCode(), 1, "-30", "-30", false)
+	check("u1", "70", "30")
+
+	// SUCCEED captures part of it and releases the rest.
+	hold(1, 2, "-20", "-30", true)
+	check("u1", "80", "0")
+	check("m1", "20", "0")
+
+	// CANCEL and FAIL release the full hold.
+	for _, target := range []int64{3, 4} {
+		hold(meta.UnsetCode(), 1, "-50", "-50", false)
+		check("u1", "30", "50")
+		hold(1, target, "-50", "-50", false)
+		check("u1", "80", "0")
+	}
+}
@@ -19,7 +19,10 @@ func (r *Recharge) ServeOpay(ctx *opay.Context) error {
 	if ctx.HasStakeholder() {
 		return opay.ErrExtraStakeholder
 	}
-	if ctx.SmallerOrEqual(ctx.Request.Initiator.GetAmount(), 0) {
+	if ctx.HasParties() {
+		return opay.ErrExtraParty
+	}
+	if ctx.Request.Initiator.GetAmount().Sign() <= 0 {
 		return opay.ErrIncorrectAmount
 	}
 	return r.Call(r, ctx)
@@ -0,0 +1,138 @@
+package handles
+
+import (
+	"github.com/henrylee2cn/opay"
+	"github.com/jmoiron/sqlx"
+)
+
+type (
+	/*
+	 * 退款
+	 * Request.Initiator为退款订单（通过BaseOrder.LinkId关联原订单），金额与原订单符号相反；
+	 * Request.Stakeholder可选，为原订单对方的退款订单，金额与Initiator相反。
+	 * 支持多次部分退款，累计退款金额不可超过原订单金额。
+	 */
+	Refund struct {
+		Background
+	}
+
+	// 退款订单接口，由Request.Initiator实现
+	Refundable interface {
+		opay.IOrder
+		// 返回原订单及其已成功退款的累计金额（不含本次），
+		// 应在事务中锁定原订单（如SELECT ... FOR UPDATE），防止并发超额退款
+		RefundState(tx *sqlx.Tx) (original opay.IOrder, refunded opay.Amount, err error)
+	}
+
+	// 关联订单接口，返回原订单对方的用户ID与资产ID，base.BaseOrder已实现；
+	// 存在Request.Stakeholder时，原订单须实现该接口
+	Linked interface {
+		GetLinkUid() string
+		GetLinkAid() string
+	}
+)
+
+// 编译期检查接口实现
+var _ Handler = (*Refund)(nil)
+
+// 执行入口
+func (r *Refund) ServeOpay(ctx *opay.Context) error {
+	if _, ok := ctx.Request.Initiator.(Refundable); !ok {
+		return opay.ErrNotRefundable
+	}
+	if ctx.HasParties() {
+		return opay.ErrExtraParty
+	}
+	amount := ctx.Request.Initiator.GetAmount()
+	if amount.IsZero() {
+		return opay.ErrIncorrectAmount
+	}
+	if ctx.HasStakeholder() &&
+		!ctx.Request.Stakeholder.GetAmount().Equal(amount.Neg()) {
+		return opay.ErrIncorrectAmount
+	}
+	return r.Call(r, ctx)
+}
+
+// 新建退款订单，并标记为等待处理状态
+func (r *Refund) Pend() error {
+	err := r.check()
+	if err != nil {
+		return err
+	}
+	return r.Background.Context.Pend()
+}
+
+// 退回账户并标记订单为成功状态
+func (r *Refund) Succeed() error {
+	err := r.check()
+	if err != nil {
+		return err
+	}
+
+	// 操作账户
+	err = r.Background.Context.UpdateBalance()
+	if err != nil {
+		return err
+	}
+
+	// 更新订单
+	return r.Background.Context.Succeed()
+}
+
+// 实时退款
+func (r *Refund) SyncDeal() error {
+	err := r.check()
+	if err != nil {
+		return err
+	}
+
+	// 操作账户
+	err = r.Background.Context.UpdateBalance()
+	if err != nil {
+		return err
+	}
+
+	// 更新订单
+	return r.Background.Context.SyncDeal()
+}
+
+// 检查原订单已完成，Stakeholder为原订单的对方，且累计退款金额不超过原订单金额
+func (r *Refund) check() error {
+	refund := r.Request.Initiator.(Refundable)
+	original, refunded, err := refund.RefundState(r.Request.Tx)
+	if err != nil {
+		return err
+	}
+	if original == nil {
+		return opay.ErrNotRefundable
+	}
+	status, ok := original.GetMeta().Status(original.TargetStatus())
+	if !ok {
+		return opay.ErrInvalidStatus
+	}
+	if status.Step != opay.SUCCEED && status.Step != opay.SYNC_DEAL {
+		return opay.ErrNotRefundable
+	}
+	if original.GetUid() != refund.GetUid() || original.GetAid() != refund.GetAid() {
+		return opay.ErrNotRefundable
+	}
+	if r.HasStakeholder() {
+		linked, ok := original.(Linked)
+		if !ok {
+			return opay.ErrNotRefundable
+		}
+		stakeholder := r.Request.Stakeholder
+		if stakeholder.GetUid() != linked.GetLinkUid() || stakeholder.GetAid() != linked.GetLinkAid() {
+			return opay.ErrNotRefundable
+		}
+	}
+	amount := refund.GetAmount()
+	if original.GetAmount().Sign()*amount.Sign() >= 0 {
+		return opay.ErrIncorrectAmount
+	}
+	if refunded.Add(amount).Abs().Cmp(original.GetAmount().Abs()) > 0 {
+		return opay.ErrRefundExceeded
+	}
+	return nil
+}
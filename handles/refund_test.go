@@ -0,0 +1,145 @@
+package handles
+
+import (
+	"context"
+	"testing"
+
+	"github.com/henrylee2cn/opay"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type testOrder struct {
+	meta    *opay.Meta
+	pre     int64
+	target  int64
+	uid     string
+	aid     string
+	amount  opay.Amount
+	linkUid string
+}
+
+func (o *testOrder) GetMeta() *opay.Meta              { return o.meta }
+func (o *testOrder) PreStatus() int64                 { return o.pre }
+func (o *testOrder) TargetStatus() int64              { return o.target }
+func (o *testOrder) GetUid() string                   { return o.uid }
+func (o *testOrder) GetAid() string                   { return o.aid }
+func (o *testOrder) GetAmount() opay.Amount           { return o.amount }
+func (o *testOrder) GetLinkUid() string               { return o.linkUid }
+func (o *testOrder) GetLinkAid() string               { return o.aid }
+func (o *testOrder) Pend(*sqlx.Tx, opay.KV) error     { return nil }
+func (o *testOrder) Do(*sqlx.Tx, opay.KV) error       { return nil }
+func (o *testOrder) Succeed(*sqlx.Tx, opay.KV) error  { return nil }
+func (o *testOrder) Cancel(*sqlx.Tx, opay.KV) error   { return nil }
+func (o *testOrder) Fail(*sqlx.Tx, opay.KV) error     { return nil }
+func (o *testOrder) SyncDeal(*sqlx.Tx, opay.KV) error { return nil }
+
+// The refund order, accumulating the refunded amount of the original order.
+type testRefund struct {
+	testOrder
+	original *testOrder
+	refunded *opay.Amount
+}
+
+func (o *testRefund) RefundState(*sqlx.Tx) (opay.IOrder, opay.Amount, error) {
+	return o.original, *o.refunded, nil
+}
+
+func (o *testRefund) SyncDeal(*sqlx.Tx, opay.KV) error {
+	*o.refunded = o.refunded.Add(o.amount)
+	return nil
+}
+
+// Starts an opay with the in-memory sqlite database, settling the asset "1" to balances.
+func newTestOpay(t *testing.T, balances map[string]opay.Amount) *opay.Opay {
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	settles := opay.NewSettleFuncMap()
+	settles.RegSettleFunc("1", func(uid string, amount opay.Amount, _ *sqlx.Tx) error {
+		balances[uid] = balances[uid].Add(amount)
+		return nil
+	})
+	o := opay.New(db, opay.WithSettleFuncMap(settles))
+	t.Cleanup(func() {
+		o.Shutdown(context.Background())
+		db.Close()
+	})
+	return o
+}
+
+func TestRefund(t *testing.T) {
+	balances := make(map[string]opay.Amount)
+	o := newTestOpay(t, balances)
+	pay, err := o.RegMeta("pay", opay.HandlerFunc(nil), []opay.Status{
+		{Code: 1, Note: "paid", Step: opay.SUCCEED},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := o.RegMeta("refund", &Refund{}, []opay.Status{
+		{Code: 1, Note: "refunded", Step: opay.SYNC_DEAL},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = o.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	original := &testOrder{meta: pay, target: 1, uid: "u1", aid: "1", amount: opay.MustParseAmount("-100"), linkUid: "m1"}
+	var refunded opay.Amount
+	refund := func(stakeholder, amount string) error {
+		a := opay.MustParseAmount(amount)
+		req := opay.Request{
+			Initiator: &testRefund{
+				testOrder: testOrder{meta: meta, pre: meta.UnsetCode(), target: 1, uid: "u1", aid: "1", amount: a},
+				original:  original,
+				refunded:  &refunded,
+			},
+		}
+		if len(stakeholder) > 0 {
+			req.Stakeholder = &testOrder{meta: meta, pre: meta.UnsetCode(), target: 1, uid: stakeholder, aid: "1", amount: a.Neg()}
+		}
+		return o.Do(req).Err
+	}
+
+	if err = refund("m1", "30"); err != nil {
+		t.Fatalf("refund: %v", err)
+	}
+	if err = refund("evil", "30"); err != opay.ErrNotRefundable {
+		t.Fatalf("stakeholder: %v", err)
+	}
+	if err = refund("m1", "-30"); err != opay.ErrIncorrectAmount {
+		t.Fatalf("sign: %v", err)
+	}
+	req := opay.Request{
+		Initiator: &testRefund{
+			testOrder: testOrder{meta: meta, pre: meta.UnsetCode(), target: 1, uid: "u1", aid: "1", amount: opay.MustParseAmount("10")},
+			original:  original,
+			refunded:  &refunded,
+		},
+		Stakeholder: &testOrder{meta: meta, pre: meta.UnsetCode(), target: 1, uid: "m1", aid: "1", amount: opay.MustParseAmount("-5")},
+		Parties:     []opay.IOrder{&testOrder{meta: meta, pre: meta.UnsetCode(), target: 1, uid: "evil", aid: "1", amount: opay.MustParseAmount("-5")}},
+	}
+	if err = o.Do(req).Err; err != opay.ErrExtraParty {
+		t.Fatalf("parties: %v", err)
+	}
+	if err = refund("m1", "80"); err != opay.ErrRefundExceeded {
+		t.Fatalf("exceeded: %v", err)
+	}
+	if err = refund("", "70"); err != nil {
+		t.Fatalf("refund: %v", err)
+	}
+	if err = refund("m1", "0.01"); err != opay.ErrRefundExceeded {
+		t.Fatalf("cumulative: %v", err)
+	}
+	if !refunded.Equal(opay.MustParseAmount("100")) ||
+		!balances["u1"].Equal(opay.MustParseAmount("100")) ||
+		!balances["m1"].Equal(opay.MustParseAmount("-30")) ||
+		!balances["evil"].IsZero() {
+		t.Fatalf("refunded: %s, balances: %v", refunded, balances)
+	}
+}
@@ -14,20 +14,34 @@ type Transfer struct {
 // 编译期检查接口实现
 var _ Handler = (*Transfer)(nil)
 
-// 执行入口
+// 执行入口，
+// 可通过Request.Parties分账给多个收款方，
+// 付款金额须等于各收款金额与手续费（按收款金额之和计算）之和
 func (t *Transfer) ServeOpay(ctx *opay.Context) error {
 	if !ctx.HasStakeholder() {
 		return opay.ErrStakeholderNotExist
 	}
-	if ctx.GreaterOrEqual(ctx.Request.Initiator.GetAmount(), 0) ||
-		ctx.SmallerOrEqual(ctx.Request.Stakeholder.GetAmount(), 0) ||
-		!ctx.Equal(ctx.Request.Initiator.GetAmount(), -ctx.Request.Stakeholder.GetAmount()) {
+	if ctx.Request.Initiator.GetAmount().Sign() >= 0 {
+		return opay.ErrIncorrectAmount
+	}
+	var received opay.Amount
+	for _, order := range append([]opay.IOrder{ctx.Request.Stakeholder}, ctx.Request.Parties...) {
+		if order.GetAmount().Sign() <= 0 {
+			return opay.ErrIncorrectAmount
+		}
+		received = received.Add(order.GetAmount())
+	}
+	fee, err := ctx.Fee(received)
+	if err != nil {
+		return err
+	}
+	if !ctx.Request.Initiator.GetAmount().Add(received).Add(fee).IsZero() {
 		return opay.ErrIncorrectAmount
 	}
 	return t.Call(t, ctx)
 }
 
-// 处理账户并标记订单为成功状态，
+// 处理账户（含手续费）并标记订单为成功状态，
 // IOrder.Succeed()中应包含Uid2的订单创建与标记成功
 func (t *Transfer) Succeed() error {
 	// 操作账户
@@ -40,7 +54,7 @@ func (t *Transfer) Succeed() error {
 	return t.Background.Context.Succeed()
 }
 
-// 实时转账
+// 实时转账（含手续费）
 func (t *Transfer) SyncDeal() error {
 	// 操作账户
 	err := t.Background.Context.UpdateBalance()
@@ -0,0 +1,113 @@
+package handles
+
+import (
+	"testing"
+
+	"github.com/henrylee2cn/opay"
+	"github.com/henrylee2cn/opay/ledger"
+)
+
+func TestTransferFeeZeroSum(t *testing.T) {
+	balances := make(map[string]opay.Amount)
+	o := newTestOpay(t, balances)
+	s := opay.NewFeeSchedule()
+	s.SetRule("", "", opay.PercentFee{Rate: opay.MustParseAmount("0.01")})
+	if err := o.SetFeeEngine(s, "fee"); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := o.RegMeta("transfer", &Transfer{}, []opay.Status{
+		{Code: 1, Note: "transferred", Step: opay.SYNC_DEAL},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta.SetZeroSum(true)
+	if err = o.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	order := func(uid, amount string) opay.IOrder {
+		return &testOrder{meta: meta, pre: meta.UnsetCode(), target: 1, uid: uid, aid: "1", amount: opay.MustParseAmount(amount)}
+	}
+	err = o.Do(opay.Request{
+		Initiator:   order("buyer", "-101"),
+		Stakeholder: order("seller", "80"),
+		Parties:     []opay.IOrder{order("platform", "20")},
+	}).Err
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = o.Do(opay.Request{
+		Initiator:   order("buyer", "-100"),
+		Stakeholder: order("seller", "100"),
+	}).Err
+	if err != opay.ErrIncorrectAmount {
+		t.Fatalf("without fee: %v", err)
+	}
+	for uid, want := range map[string]string{"buyer": "-101", "seller": "80", "platform": "20", "fee": "1"} {
+		if !balances[uid].Equal(opay.MustParseAmount(want)) {
+			t.Fatalf("%s: %s, want %s", uid, balances[uid], want)
+		}
+	}
+}
+
+func TestFeeLedger(t *testing.T) {
+	balances := make(map[string]opay.Amount)
+	o := newTestOpay(t, balances)
+	s := opay.NewFeeSchedule()
+	s.SetRule("", "", opay.FixedFee{Amount: opay.MustParseAmount("1")})
+	if err := o.SetFeeEngine(s, "fee"); err != nil {
+		t.Fatal(err)
+	}
+	l := ledger.New(o.DB(), "ledger")
+	if err := l.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+	l.SetSingleSided("withdraw")
+	if err := o.SetJournal(l); err != nil {
+		t.Fatal(err)
+	}
+	transfer, err := o.RegMeta("transfer", &Transfer{}, []opay.Status{
+		{Code: 1, Note: "transferred", Step: opay.SYNC_DEAL},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	withdraw, err := o.RegMeta("withdraw", &Withdraw{}, []opay.Status{
+		{Code: 1, Note: "pending", Step: opay.PEND},
+		{Code: 2, Note: "withdrawn", Step: opay.SUCCEED},
+		{Code: 3, Note: "canceled", Step: opay.CANCEL},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = o.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	err = o.Do(opay.Request{
+		Initiator:   &testOrder{meta: transfer, pre: transfer.UnsetCode(), target: 1, uid: "buyer", aid: "1", amount: opay.MustParseAmount("-101")},
+		Stakeholder: &testOrder{meta: transfer, pre: transfer.UnsetCode(), target: 1, uid: "seller", aid: "1", amount: opay.MustParseAmount("100")},
+	}).Err
+	if err != nil {
+		t.Fatalf("transfer: %v", err)
+	}
+	for _, c := range []struct{ pre, target int64 }{{withdraw.UnsetCode(), 1}, {1, 2}, {withdraw.UnsetCode(), 1}, {1, 3}} {
+		err = o.Do(opay.Request{
+			Initiator: &testOrder{meta: withdraw, pre: c.pre, target: c.target, uid: "seller", aid: "1", amount: opay.MustParseAmount("-50")},
+		}).Err
+		if err != nil {
+			t.Fatalf("withdraw %d -> %d: %v", c.pre, c.target, err)
+		}
+	}
+
+	for uid, want := range map[string]string{"buyer": "-101", "seller": "50", "fee": "2", "@withdraw": "49"} {
+		balance, err := l.Balance(uid, "1")
+		if err != nil || !balance.Equal(opay.MustParseAmount(want)) {
+			t.Fatalf("%s: %s %v, want %s", uid, balance, err, want)
+		}
+		if uid[0] != '@' && !balances[uid].Equal(balance) {
+			t.Fatalf("%s: settled %s, posted %s", uid, balances[uid], balance)
+		}
+	}
+}
@@ -20,14 +20,25 @@ func (w *Withdraw) ServeOpay(ctx *opay.Context) error {
 	if ctx.HasStakeholder() {
 		return opay.ErrExtraStakeholder
 	}
-	if ctx.GreaterOrEqual(ctx.Request.Initiator.GetAmount(), 0) {
+	if ctx.HasParties() {
+		return opay.ErrExtraParty
+	}
+	if ctx.Request.Initiator.GetAmount().Sign() >= 0 {
+		return opay.ErrIncorrectAmount
+	}
+	// 手续费包含在提现金额中
+	fee, err := ctx.Fee(ctx.Request.Initiator.GetAmount())
+	if err != nil {
+		return err
+	}
+	if fee.Cmp(ctx.Request.Initiator.GetAmount().Abs()) >= 0 {
 		return opay.ErrIncorrectAmount
 	}
 	return w.Call(w, ctx)
 }
 
 // 新建订单，并标记为等待处理状态，
-// 先从账户扣除提现金额。
+// 先从账户扣除提现金额，同时收取手续费。
 func (w *Withdraw) Pend() error {
 	// 操作账户
 	err := w.Background.Context.UpdateBalance()
@@ -39,14 +50,14 @@ func (w *Withdraw) Pend() error {
 	return w.Background.Context.Pend()
 }
 
-// 处理账户并标记订单为成功状态
+// 标记订单为成功状态
 func (w *Withdraw) Succeed() error {
 	return w.Background.Context.Succeed()
 }
 
 // 标记订单为撤销状态
 func (w *Withdraw) Cancel() error {
-	// 回滚账户
+	// 回滚账户（含手续费）
 	err := w.Background.Context.RollbackBalance()
 	if err != nil {
 		return err
@@ -58,7 +69,7 @@ func (w *Withdraw) Cancel() error {
 
 // 标记订单为失败状态
 func (w *Withdraw) Fail() error {
-	// 回滚账户
+	// 回滚账户（含手续费）
 	err := w.Background.Context.RollbackBalance()
 	if err != nil {
 		return err
@@ -0,0 +1,139 @@
+package opay
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type (
+	// IdempotencyRecord is the record of a succeeded request with idempotency key.
+	IdempotencyRecord struct {
+		Key         string `json:"key" db:"idem_key"`
+		Fingerprint string `json:"fingerprint" db:"fingerprint"` //identifies the business operation
+		OrderType   string `json:"order_type" db:"order_type"`
+		CreatedAt   int64  `json:"created_at" db:"created_at"`
+	}
+
+	// IdempotencyStore stores the records of the succeeded requests with idempotency keys.
+	IdempotencyStore interface {
+		// Get returns the record of the key, or nil if not exist.
+		Get(key string) (*IdempotencyRecord, error)
+		// Put saves the record in the request's transaction, fails if the key exists.
+		Put(tx *sqlx.Tx, record *IdempotencyRecord) error
+	}
+
+	// DBIdempotencyStore is an IdempotencyStore on a table.
+	DBIdempotencyStore struct {
+		db      *sqlx.DB
+		dialect Dialect
+		table   string
+	}
+)
+
+var _ IdempotencyStore = (*DBIdempotencyStore)(nil)
+
+// NewDBIdempotencyStore creates an IdempotencyStore on the table.
+func NewDBIdempotencyStore(db *sqlx.DB, table string) *DBIdempotencyStore {
+	return &DBIdempotencyStore{
+		db:      db,
+		dialect: DialectOf(db.DriverName()),
+		table:   table,
+	}
+}
+
+// CreateTable creates the table if not exists.
+func (s *DBIdempotencyStore) CreateTable() error {
+	return s.dialect.Exec(s.db, s.dialect.CreateTable(s.table, []string{
+		"idem_key VARCHAR(128) NOT NULL PRIMARY KEY",
+		"fingerprint CHAR(64) NOT NULL",
+		"order_type VARCHAR(64) NOT NULL",
+		"created_at BIGINT NOT NULL",
+	}, "created_at"))
+}
+
+// Get returns the record of the key, or nil if not exist.
+func (s *DBIdempotencyStore) Get(key string) (*IdempotencyRecord, error) {
+	var record IdempotencyRecord
+	err := s.db.Get(&record, s.db.Rebind(
+		"SELECT idem_key, fingerprint, order_type, created_at FROM "+s.table+" WHERE idem_key = ?"),
+		key,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// Put saves the record in the transaction, fails if the key exists.
+func (s *DBIdempotencyStore) Put(tx *sqlx.Tx, record *IdempotencyRecord) error {
+	_, err := tx.Exec(tx.Rebind(
+		"INSERT INTO "+s.table+" (idem_key, fingerprint, order_type, created_at) VALUES (?, ?, ?, ?)"),
+		record.Key, record.Fingerprint, record.OrderType, record.CreatedAt,
+	)
+	return err
+}
+
+// Purge deletes the records created before the time.
+func (s *DBIdempotencyStore) Purge(before time.Time) (int64, error) {
+	result, err := s.db.Exec(s.db.Rebind(
+		"DELETE FROM "+s.table+" WHERE created_at < ?"),
+		before.Unix(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Returns the idempotency record of the request created at now.
+func (req *Request) idempotencyRecord(now time.Time) *IdempotencyRecord {
+	h := sha256.New()
+	h.Write([]byte(req.Operator() + "\x00" + strconv.Itoa(int(req.Step()))))
+	for _, order := range req.orders() {
+		h.Write([]byte("\x00" + order.GetUid() + "\x00" + order.GetAid() + "\x00" + order.GetAmount().String()))
+	}
+	return &IdempotencyRecord{
+		Key:         req.IdempotencyKey,
+		Fingerprint: hex.EncodeToString(h.Sum(nil)),
+		OrderType:   req.Operator(),
+		CreatedAt:   now.Unix(),
+	}
+}
+
+// Checks whether the request has succeeded before.
+func (opay *Opay) replayed(record *IdempotencyRecord) (bool, error) {
+	origin, err := opay.idempotency.Get(record.Key)
+	if err != nil || origin == nil {
+		return false, err
+	}
+	if origin.Fingerprint != record.Fingerprint {
+		return false, ErrIdempotencyConflict
+	}
+	return true, nil
+}
+
+// Returns nil and marks the response replayed if the failed request
+// has succeeded concurrently with the same idempotency key, such as its Put conflicts,
+// or ErrIdempotencyConflict if another request has taken the key, otherwise returns err.
+func (opay *Opay) replayFailed(req Request, record *IdempotencyRecord, err error) error {
+	if err == nil || record == nil {
+		return err
+	}
+	replayed, e := opay.replayed(record)
+	if replayed {
+		req.response.setReplayed()
+		return nil
+	}
+	if e == ErrIdempotencyConflict {
+		return e
+	}
+	return err
+}
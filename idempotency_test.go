@@ -0,0 +1,133 @@
+package opay
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Misses the records in the first Get calls,
+// as if the request checked the key before the other one committed.
+type lateIdempotencyStore struct {
+	IdempotencyStore
+	misses int
+}
+
+func (s *lateIdempotencyStore) Get(key string) (*IdempotencyRecord, error) {
+	if s.misses > 0 {
+		s.misses--
+		return nil, nil
+	}
+	return s.IdempotencyStore.Get(key)
+}
+
+// Returns the opay whose "test" handler writes an effect in the request's transaction.
+func newIdempotencyOpay(t *testing.T) (*Opay, *Meta, *lateIdempotencyStore, *int) {
+	// The caller's Tx and the store hold the connections concurrently.
+	db, err := sqlx.Open("sqlite3", filepath.Join(t.TempDir(), "opay.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err = db.Exec("CREATE TABLE effects (uid VARCHAR(64) NOT NULL)"); err != nil {
+		t.Fatal(err)
+	}
+	store := NewDBIdempotencyStore(db, "opay_idempotency")
+	if err = store.CreateTable(); err != nil {
+		t.Fatal(err)
+	}
+	late := &lateIdempotencyStore{IdempotencyStore: store}
+	o := New(db, WithSettleFuncMap(newTestSettles()))
+	if err = o.SetIdempotencyStore(late); err != nil {
+		t.Fatal(err)
+	}
+	var calls int
+	meta, err := o.RegMeta("test", HandlerFunc(func(ctx *Context) error {
+		calls++
+		_, err := ctx.Request.Tx.Exec("INSERT INTO effects (uid) VALUES (?)", ctx.Request.Initiator.GetUid())
+		return err
+	}), []Status{
+		{Code: 1, Note: "pend", Step: PEND},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	startTestOpay(t, o)
+	return o, meta, late, &calls
+}
+
+// Checks the number of the committed effects.
+func checkEffects(t *testing.T, db *sqlx.DB, want int) {
+	t.Helper()
+	var n int
+	if err := db.Get(&n, "SELECT COUNT(*) FROM effects"); err != nil {
+		t.Fatal(err)
+	}
+	if n != want {
+		t.Fatalf("effects: %d, want %d", n, want)
+	}
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	o, meta, _, calls := newIdempotencyOpay(t)
+	do := func(amount float64) *Response {
+		req := newTestRequest(meta, "u1", amount)
+		req.IdempotencyKey = "k1"
+		return o.Do(req)
+	}
+	if resp := do(1); resp.Err != nil || resp.Replayed {
+		t.Fatalf("first: %v %v", resp.Err, resp.Replayed)
+	}
+	if resp := do(1); resp.Err != nil || !resp.Replayed || *calls != 1 {
+		t.Fatalf("replay: %v %v, handled %d times", resp.Err, resp.Replayed, *calls)
+	}
+	if resp := do(2); resp.Err != ErrIdempotencyConflict || *calls != 1 {
+		t.Fatalf("fingerprint: %v, handled %d times", resp.Err, *calls)
+	}
+	checkEffects(t, o.DB(), 1)
+}
+
+func TestIdempotencyConcurrent(t *testing.T) {
+	o, meta, store, calls := newIdempotencyOpay(t)
+	newRequest := func(amount float64) Request {
+		req := newTestRequest(meta, "u1", amount)
+		req.IdempotencyKey = "k1"
+		return req
+	}
+	if err := o.Do(newRequest(1)).Err; err != nil {
+		t.Fatal(err)
+	}
+
+	// The concurrent one checked the key before the first committed,
+	// its Put conflicts and it is rolled back as a replay.
+	store.misses = 1
+	if resp := o.Do(newRequest(1)); resp.Err != nil || !resp.Replayed || *calls != 2 {
+		t.Fatalf("concurrent: %v %v, handled %d times", resp.Err, resp.Replayed, *calls)
+	}
+	store.misses = 1
+	if resp := o.Do(newRequest(2)); resp.Err != ErrIdempotencyConflict {
+		t.Fatalf("concurrent fingerprint: %v", resp.Err)
+	}
+	checkEffects(t, o.DB(), 1)
+
+	// So is the one in the caller's Tx, whose writes are rolled back to the savepoint.
+	tx, err := o.DB().Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if _, err = tx.Exec("INSERT INTO effects (uid) VALUES ('caller')"); err != nil {
+		t.Fatal(err)
+	}
+	store.misses = 1
+	req := newRequest(1)
+	req.Tx = tx
+	if resp := o.Do(req); resp.Err != nil || !resp.Replayed {
+		t.Fatalf("caller's Tx: %v %v", resp.Err, resp.Replayed)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	checkEffects(t, o.DB(), 2)
+}
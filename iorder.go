@@ -24,7 +24,7 @@ type (
 
 		// Get the amount of change for the Uid-Aid account,
 		// balance of positive and negative representation.
-		GetAmount() float64
+		GetAmount() Amount
 
 		// Async execution, and mark pending.
 		Pend(*sqlx.Tx, KV) error
@@ -0,0 +1,45 @@
+package opay
+
+import (
+	"github.com/jmoiron/sqlx"
+)
+
+type (
+	// BalanceChange is a change of the Uid-Aid account balance caused by an order.
+	BalanceChange struct {
+		OrderId string //empty if the order has no GetId method
+		Uid     string
+		Aid     string
+		Amount  Amount
+	}
+
+	// Posting is the balance changes of a request in a step.
+	Posting struct {
+		OrderId   string //id of the initiator order
+		OrderType string
+		Step      Step
+		Changes   []BalanceChange
+	}
+
+	// Journal records the balance changes in the request's transaction.
+	Journal interface {
+		Post(tx *sqlx.Tx, posting *Posting) error
+	}
+)
+
+// OrderId returns the id of the order, if it implements GetId() string.
+func OrderId(order IOrder) string {
+	if o, ok := UnwrapFloatOrder(order); ok {
+		return orderId(o)
+	}
+	return orderId(order)
+}
+
+func orderId(order interface{}) string {
+	if o, ok := order.(interface {
+		GetId() string
+	}); ok {
+		return o.GetId()
+	}
+	return ""
+}
@@ -0,0 +1,118 @@
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/henrylee2cn/opay"
+)
+
+type (
+	// Entry is a balanced journal entry of a request in a step,
+	// the amounts of its lines sum to zero per asset.
+	Entry struct {
+		Id        int64     `json:"id" db:"id"`
+		OrderId   string    `json:"order_id" db:"order_id"` //id of the initiator order
+		OrderType string    `json:"order_type" db:"order_type"`
+		Step      opay.Step `json:"step" db:"step"`
+		CreatedAt int64     `json:"created_at" db:"created_at"`
+		Lines     []*Line   `json:"lines" db:"-"`
+	}
+
+	// Line is a debit or credit of an account in the entry.
+	// The positive amount credits the Uid-Aid account, increasing its balance,
+	// and the negative one debits it.
+	Line struct {
+		Id        int64       `json:"id" db:"id"`
+		EntryId   int64       `json:"entry_id" db:"entry_id"`
+		OrderId   string      `json:"order_id" db:"order_id"`
+		OrderType string      `json:"order_type" db:"order_type"`
+		Step      opay.Step   `json:"step" db:"step"`
+		Uid       string      `json:"uid" db:"uid"`
+		Aid       string      `json:"aid" db:"aid"`
+		Amount    opay.Amount `json:"amount" db:"amount"`
+		CreatedAt int64       `json:"created_at" db:"created_at"`
+	}
+)
+
+// ErrUnbalanced is returned if the lines of an entry do not sum to zero per asset.
+var ErrUnbalanced = errors.New("ledger: the entry is unbalanced.")
+
+// NewEntry creates the entry of the posting,
+// the unbalanced amount of each asset is booked to the contra account,
+// unless contra is nil or returns empty, then the entry fails to validate.
+func NewEntry(posting *opay.Posting, contra func(orderType, aid string) string, createdAt int64) *Entry {
+	e := &Entry{
+		OrderId:   posting.OrderId,
+		OrderType: posting.OrderType,
+		Step:      posting.Step,
+		CreatedAt: createdAt,
+	}
+	for _, change := range posting.Changes {
+		e.add(change.OrderId, change.Uid, change.Aid, change.Amount)
+	}
+	for _, aid := range e.assets() {
+		sum := e.Sum(aid)
+		if sum.IsZero() || contra == nil {
+			continue
+		}
+		if uid := contra(posting.OrderType, aid); len(uid) > 0 {
+			e.add(posting.OrderId, uid, aid, sum.Neg())
+		}
+	}
+	return e
+}
+
+// Sum returns the sum of the line amounts of the asset.
+func (e *Entry) Sum(aid string) opay.Amount {
+	var sum opay.Amount
+	for _, line := range e.Lines {
+		if line.Aid == aid {
+			sum = sum.Add(line.Amount)
+		}
+	}
+	return sum
+}
+
+// Validate checks that the lines sum to zero per asset.
+func (e *Entry) Validate() error {
+	if len(e.Lines) == 0 {
+		return errors.New("ledger: the entry has no line.")
+	}
+	for _, aid := range e.assets() {
+		if sum := e.Sum(aid); !sum.IsZero() {
+			return fmt.Errorf("%w aid: %s, sum: %s", ErrUnbalanced, aid, sum)
+		}
+	}
+	return nil
+}
+
+func (e *Entry) add(orderId, uid, aid string, amount opay.Amount) {
+	e.Lines = append(e.Lines, &Line{
+		EntryId:   e.Id,
+		OrderId:   orderId,
+		OrderType: e.OrderType,
+		Step:      e.Step,
+		Uid:       uid,
+		Aid:       aid,
+		Amount:    amount,
+		CreatedAt: e.CreatedAt,
+	})
+}
+
+// Returns the sorted assets of the lines.
+func (e *Entry) assets() []string {
+	var (
+		aids []string
+		seen = make(map[string]bool)
+	)
+	for _, line := range e.Lines {
+		if !seen[line.Aid] {
+			seen[line.Aid] = true
+			aids = append(aids, line.Aid)
+		}
+	}
+	sort.Strings(aids)
+	return aids
+}
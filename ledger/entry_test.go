@@ -0,0 +1,67 @@
+package ledger
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/henrylee2cn/opay"
+)
+
+func TestNewEntry(t *testing.T) {
+	// Exchange: -10 USD, +70 CNY.
+	e := NewEntry(&opay.Posting{
+		OrderId:   "o1",
+		OrderType: "exchange",
+		Step:      opay.SUCCEED,
+		Changes: []opay.BalanceChange{
+			{OrderId: "o2", Uid: "u1", Aid: "2", Amount: opay.MustParseAmount("70.00")},
+			{OrderId: "o1", Uid: "u1", Aid: "1", Amount: opay.MustParseAmount("-10.00")},
+		},
+	}, DefaultContra, 0)
+	if err := e.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.Lines) != 4 {
+		t.Fatalf("lines: %d", len(e.Lines))
+	}
+	for _, line := range e.Lines[2:] {
+		if line.Uid != "@exchange" || line.OrderId != "o1" {
+			t.Fatalf("contra line: %+v", line)
+		}
+	}
+
+	// Transfer is balanced without contra lines.
+	e = NewEntry(&opay.Posting{
+		OrderType: "transfer",
+		Changes: []opay.BalanceChange{
+			{Uid: "u2", Aid: "1", Amount: opay.MustParseAmount("5")},
+			{Uid: "u1", Aid: "1", Amount: opay.MustParseAmount("-5.0")},
+		},
+	}, DefaultContra, 0)
+	if len(e.Lines) != 2 {
+		t.Fatalf("lines: %d", len(e.Lines))
+	}
+
+	e.Lines[0].Amount = opay.MustParseAmount("4")
+	if e.Validate() == nil {
+		t.Fatal("unbalanced entry is valid")
+	}
+}
+
+func TestNewEntryWithoutContra(t *testing.T) {
+	posting := &opay.Posting{
+		OrderId:   "o1",
+		OrderType: "transfer",
+		Changes: []opay.BalanceChange{
+			{OrderId: "o1", Uid: "u1", Aid: "1", Amount: opay.MustParseAmount("-5")},
+			{OrderId: "o2", Uid: "u2", Aid: "1", Amount: opay.MustParseAmount("4")},
+		},
+	}
+	noContra := func(orderType, aid string) string { return "" }
+	for _, contra := range []func(string, string) string{nil, noContra} {
+		e := NewEntry(posting, contra, 0)
+		if len(e.Lines) != 2 || !errors.Is(e.Validate(), ErrUnbalanced) {
+			t.Fatalf("unbalanced without contra: %d lines, %v", len(e.Lines), e.Validate())
+		}
+	}
+}
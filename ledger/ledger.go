@@ -0,0 +1,176 @@
+// Package ledger records every balance change of opay as a balanced double-entry journal entry.
+//
+// Register it with Opay.SetJournal, the entries are written in the request's transaction.
+// The postings must be balanced, except the ones of the declared single-sided order types,
+// such as recharge and withdraw, whose unbalanced amounts are booked to the contra accounts:
+//
+//	l := ledger.New(db, "opay_ledger")
+//	l.CreateTables()
+//	l.SetSingleSided("recharge", "withdraw", "exchange")
+//	opay.SetJournal(l)
+package ledger
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/henrylee2cn/opay"
+	"github.com/jmoiron/sqlx"
+)
+
+// Ledger is an opay.Journal on the tables '<table>_entry' and '<table>_line'.
+type Ledger struct {
+	db      *sqlx.DB
+	dialect opay.Dialect
+	entries string
+	lines   string
+	contra  func(orderType, aid string) string
+	single  map[string]bool //single-sided order types
+	mu      sync.RWMutex
+}
+
+var _ opay.Journal = (*Ledger)(nil)
+
+// New creates a ledger on the tables prefixed with table.
+func New(db *sqlx.DB, table string) *Ledger {
+	return &Ledger{
+		db:      db,
+		dialect: opay.DialectOf(db.DriverName()),
+		entries: table + "_entry",
+		lines:   table + "_line",
+		contra:  DefaultContra,
+		single:  make(map[string]bool),
+	}
+}
+
+// DefaultContra returns the contra account '@<orderType>' of the system,
+// e.g. '@recharge' books the money from outside for the recharge orders.
+func DefaultContra(orderType, aid string) string {
+	return "@" + orderType
+}
+
+// SetContra sets the function returning the contra account uid,
+// which books the unbalanced amount of each asset of the single-sided order types.
+func (l *Ledger) SetContra(contra func(orderType, aid string) string) {
+	l.mu.Lock()
+	l.contra = contra
+	l.mu.Unlock()
+}
+
+// SetSingleSided declares the order types moving the money from or to outside,
+// whose unbalanced amounts are booked to the contra accounts.
+// The postings of the other order types fail with ErrUnbalanced if unbalanced.
+func (l *Ledger) SetSingleSided(orderType ...string) {
+	l.mu.Lock()
+	for _, t := range orderType {
+		l.single[t] = true
+	}
+	l.mu.Unlock()
+}
+
+// CreateTables creates the tables if not exist.
+func (l *Ledger) CreateTables() error {
+	err := l.dialect.Exec(l.db, l.dialect.CreateTable(l.entries, []string{
+		"id " + l.dialect.AutoIncrementKey(),
+		"order_id VARCHAR(64) NOT NULL",
+		"order_type VARCHAR(64) NOT NULL",
+		"step INT NOT NULL",
+		"created_at BIGINT NOT NULL",
+	}, "order_id"))
+	if err != nil {
+		return err
+	}
+	return l.dialect.Exec(l.db, l.dialect.CreateTable(l.lines, []string{
+		"id " + l.dialect.AutoIncrementKey(),
+		"entry_id BIGINT NOT NULL",
+		"order_id VARCHAR(64) NOT NULL",
+		"order_type VARCHAR(64) NOT NULL",
+		"step INT NOT NULL",
+		"uid VARCHAR(64) NOT NULL",
+		"aid VARCHAR(16) NOT NULL",
+		"amount " + l.dialect.Decimal() + " NOT NULL",
+		"created_at BIGINT NOT NULL",
+	}, "uid, aid, id", "entry_id"))
+}
+
+// Post implements opay.Journal, writes the balanced entry of the posting in the transaction.
+func (l *Ledger) Post(tx *sqlx.Tx, posting *opay.Posting) error {
+	var contra func(orderType, aid string) string
+	l.mu.RLock()
+	if l.single[posting.OrderType] {
+		contra = l.contra
+	}
+	l.mu.RUnlock()
+
+	e := NewEntry(posting, contra, time.Now().Unix())
+	if err := e.Validate(); err != nil {
+		return err
+	}
+	id, err := l.dialect.InsertId(tx,
+		"INSERT INTO "+l.entries+" (order_id, order_type, step, created_at) VALUES (?, ?, ?, ?)",
+		e.OrderId, e.OrderType, e.Step, e.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	e.Id = id
+	query := tx.Rebind("INSERT INTO " + l.lines + " (entry_id, order_id, order_type, step, uid, aid, amount, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)")
+	for _, line := range e.Lines {
+		line.EntryId = id
+		_, err = tx.Exec(query, line.EntryId, line.OrderId, line.OrderType, line.Step, line.Uid, line.Aid, line.Amount, line.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// History returns the lines of the Uid-Aid account, newest first.
+func (l *Ledger) History(uid, aid string, offset, limit int) ([]*Line, error) {
+	var lines []*Line
+	err := l.db.Select(&lines, l.db.Rebind(fmt.Sprintf(
+		"SELECT id, entry_id, order_id, order_type, step, uid, aid, amount, created_at FROM %s WHERE uid = ? AND aid = ? ORDER BY id DESC LIMIT %d OFFSET %d",
+		l.lines, limit, offset)),
+		uid, aid,
+	)
+	return lines, err
+}
+
+// Balance returns the sum of all the lines of the Uid-Aid account.
+func (l *Ledger) Balance(uid, aid string) (opay.Amount, error) {
+	var (
+		sum     opay.Amount
+		amounts []opay.Amount
+	)
+	err := l.db.Select(&amounts, l.db.Rebind(
+		"SELECT amount FROM "+l.lines+" WHERE uid = ? AND aid = ?"),
+		uid, aid,
+	)
+	for _, amount := range amounts {
+		sum = sum.Add(amount)
+	}
+	return sum, err
+}
+
+// Entries returns the entries of the initiator order with their lines, in order.
+func (l *Ledger) Entries(orderId string) ([]*Entry, error) {
+	var entries []*Entry
+	err := l.db.Select(&entries, l.db.Rebind(
+		"SELECT id, order_id, order_type, step, created_at FROM "+l.entries+" WHERE order_id = ? ORDER BY id"),
+		orderId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		err = l.db.Select(&e.Lines, l.db.Rebind(
+			"SELECT id, entry_id, order_id, order_type, step, uid, aid, amount, created_at FROM "+l.lines+" WHERE entry_id = ? ORDER BY id"),
+			e.Id,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,69 @@
+package ledger
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/henrylee2cn/opay"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestLedgerPost(t *testing.T) {
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	l := New(db, "ledger")
+	if err = l.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+	l.SetSingleSided("recharge")
+	post := func(posting *opay.Posting) error {
+		tx, err := db.Beginx()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Rollback()
+		if err = l.Post(tx, posting); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	err = post(&opay.Posting{
+		OrderId:   "o1",
+		OrderType: "recharge",
+		Step:      opay.SUCCEED,
+		Changes:   []opay.BalanceChange{{OrderId: "o1", Uid: "u1", Aid: "1", Amount: opay.MustParseAmount("100.00")}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The undeclared order type must be balanced.
+	err = post(&opay.Posting{
+		OrderId:   "o2",
+		OrderType: "transfer",
+		Step:      opay.SUCCEED,
+		Changes:   []opay.BalanceChange{{OrderId: "o2", Uid: "u1", Aid: "1", Amount: opay.MustParseAmount("-30.00")}},
+	})
+	if !errors.Is(err, ErrUnbalanced) {
+		t.Fatalf("unbalanced transfer: %v", err)
+	}
+
+	balance, err := l.Balance("u1", "1")
+	if err != nil || !balance.Equal(opay.MustParseAmount("100")) {
+		t.Fatalf("balance: %s %v", balance, err)
+	}
+	contra, err := l.Balance("@recharge", "1")
+	if err != nil || !contra.Equal(opay.MustParseAmount("-100")) {
+		t.Fatalf("contra balance: %s %v", contra, err)
+	}
+	entries, err := l.Entries("o1")
+	if err != nil || len(entries) != 1 || len(entries[0].Lines) != 2 {
+		t.Fatalf("entries: %v %v", entries, err)
+	}
+}
@@ -1,18 +1,27 @@
 package opay
 
 import (
-	"errors"
+	"bytes"
 	"fmt"
 	"math"
 	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
 )
 
 type (
 	Meta struct {
-		orderType string
-		handler   reflect.Value
-		statuses  map[int64]Status
-		unsetCode int64
+		orderType   string
+		handler     reflect.Value
+		statuses    map[int64]Status
+		unsetCode   int64
+		ttl         time.Duration            //the orders pending or doing longer than it are expired
+		zeroSum     bool                     //the sum of the amounts of each asset in a request must be 0
+		graph       map[int64]map[int64]bool //allowed transitions, not limited if empty
+		middlewares []Middleware
+		mu          sync.RWMutex
 	}
 	Status struct {
 		Code int64
@@ -26,7 +35,7 @@ func (o *Opay) RegMeta(orderType string, handler Handler, statuses []Status) (*M
 	defer o.metasLock.Unlock()
 	_, ok := o.metas[orderType]
 	if ok {
-		return nil, errors.New("opay: repeat regester order meta: " + orderType)
+		return nil, ErrMetaRegistered.With("opay.meta_registered.detail", orderType)
 	}
 
 	v := reflect.ValueOf(handler)
@@ -36,7 +45,7 @@ func (o *Opay) RegMeta(orderType string, handler Handler, statuses []Status) (*M
 
 	// Filters are not allowed
 	if !(v.Kind() == reflect.Struct || v.Kind() == reflect.Func) {
-		return nil, errors.New("opay: handler must be func or struct type.")
+		return nil, ErrInvalidHandler
 	}
 
 	meta := &Meta{
@@ -47,7 +56,7 @@ func (o *Opay) RegMeta(orderType string, handler Handler, statuses []Status) (*M
 	for _, status := range statuses {
 		_, ok := steps[status.Step]
 		if !ok {
-			return nil, fmt.Errorf("opay: invalid Step: %d", status.Step)
+			return nil, ErrUnknownStep.With("opay.unknown_step.detail", status.Step)
 		}
 		meta.statuses[status.Code] = status
 	}
@@ -66,61 +75,71 @@ func (o *Opay) RegMeta(orderType string, handler Handler, statuses []Status) (*M
 	return meta, nil
 }
 
-// func (o *Opay) Meta(orderType string) (*Meta, bool) {
-// 	o.metasLock.RLock()
-// 	defer o.metasLock.RUnlock()
-// 	meta, ok := o.metas[orderType]
-// 	return meta, ok
-// }
-
-// func (o *Opay) MetaStatus(orderType string, code int64) (Status, bool) {
-// 	o.metasLock.RLock()
-// 	defer o.metasLock.RUnlock()
-// 	meta, ok := o.metas[orderType]
-// 	if !ok {
-// 		return Status{}, ok
-// 	}
-// 	status, ok := meta.statuses[code], ok
-// 	return status, ok
-// }
-
-// func (o *Opay) MetaUnsetCode(orderType string) (int64, bool) {
-// 	o.metasLock.RLock()
-// 	defer o.metasLock.RUnlock()
-// 	meta, ok := o.metas[orderType]
-// 	if !ok {
-// 		return 0, ok
-// 	}
-// 	return meta.unsetCode, ok
-// }
-
-// func (o *Opay) MetaStep(orderType string, code int64) Step {
-// 	o.metasLock.RLock()
-// 	defer o.metasLock.RUnlock()
-// 	meta, ok := o.metas[orderType]
-// 	if !ok {
-// 		return UNSET
-// 	}
-// 	status, ok := meta.statuses[code]
-// 	if !ok {
-// 		return UNSET
-// 	}
-// 	return status.Step
-// }
-
-// func (o *Opay) MetaNote(orderType string, code int64) string {
-// 	o.metasLock.RLock()
-// 	defer o.metasLock.RUnlock()
-// 	meta, ok := o.metas[orderType]
-// 	if !ok {
-// 		return ""
-// 	}
-// 	status, ok := meta.statuses[code]
-// 	if !ok {
-// 		return ""
-// 	}
-// 	return status.Note
-// }
+// Meta returns the registered Meta of the order type, such as BaseOrder.Type.
+func (o *Opay) Meta(orderType string) (*Meta, bool) {
+	o.metasLock.RLock()
+	defer o.metasLock.RUnlock()
+	meta, ok := o.metas[orderType]
+	return meta, ok
+}
+
+// Metas returns all the registered Metas, in ascending order of the order types.
+func (o *Opay) Metas() []*Meta {
+	o.metasLock.RLock()
+	metas := make([]*Meta, 0, len(o.metas))
+	for _, meta := range o.metas {
+		metas = append(metas, meta)
+	}
+	o.metasLock.RUnlock()
+	sort.Slice(metas, func(i, j int) bool { return metas[i].orderType < metas[j].orderType })
+	return metas
+}
+
+// UnregMeta unregisters the Meta of the order type, and reports whether it is registered.
+// The requests of the unregistered Meta are rejected.
+func (o *Opay) UnregMeta(orderType string) bool {
+	o.metasLock.Lock()
+	defer o.metasLock.Unlock()
+	_, ok := o.metas[orderType]
+	delete(o.metas, orderType)
+	return ok
+}
+
+// Reports whether the Meta is registered.
+func (o *Opay) registered(meta *Meta) bool {
+	m, ok := o.Meta(meta.orderType)
+	return ok && m == meta
+}
+
+// MetaStatus returns the status of the order type.
+func (o *Opay) MetaStatus(orderType string, code int64) (Status, bool) {
+	meta, ok := o.Meta(orderType)
+	if !ok {
+		return Status{}, false
+	}
+	return meta.Status(code)
+}
+
+// MetaUnsetCode returns the status code of the new orders of the order type.
+func (o *Opay) MetaUnsetCode(orderType string) (int64, bool) {
+	meta, ok := o.Meta(orderType)
+	if !ok {
+		return 0, false
+	}
+	return meta.unsetCode, true
+}
+
+// MetaStep returns the step of the status of the order type, UNSET if not exist.
+func (o *Opay) MetaStep(orderType string, code int64) Step {
+	status, _ := o.MetaStatus(orderType, code)
+	return status.Step
+}
+
+// MetaNote returns the note of the status of the order type.
+func (o *Opay) MetaNote(orderType string, code int64) string {
+	status, _ := o.MetaStatus(orderType, code)
+	return status.Note
+}
 
 func (m *Meta) OrderType() string {
 	return m.orderType
@@ -135,6 +154,18 @@ func (m *Meta) Status(code int64) (Status, bool) {
 	return status, ok
 }
 
+// Statuses returns the registered statuses, in ascending order of the codes.
+func (m *Meta) Statuses() []Status {
+	statuses := make([]Status, 0, len(m.statuses)-1)
+	for code, status := range m.statuses {
+		if code != m.unsetCode {
+			statuses = append(statuses, status)
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Code < statuses[j].Code })
+	return statuses
+}
+
 func (m *Meta) Note(code int64) string {
 	status, ok := m.Status(code)
 	if !ok {
@@ -143,11 +174,157 @@ func (m *Meta) Note(code int64) string {
 	return status.Note
 }
 
+// StepStatus returns the registered status of the step with the smallest code.
+func (m *Meta) StepStatus(step Step) (Status, bool) {
+	var (
+		found  Status
+		exists bool
+	)
+	for code, status := range m.statuses {
+		if status.Step == step && code != m.unsetCode && (!exists || code < found.Code) {
+			found, exists = status, true
+		}
+	}
+	return found, exists
+}
+
+// SetTTL sets the time to live of the orders in PEND or DO step,
+// the expired ones are canceled or failed by the Reaper. No limit if zero.
+func (m *Meta) SetTTL(ttl time.Duration) {
+	m.mu.Lock()
+	m.ttl = ttl
+	m.mu.Unlock()
+}
+
+// TTL returns the time to live of the orders in PEND or DO step.
+func (m *Meta) TTL() time.Duration {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.ttl
+}
+
+// SetZeroSum sets whether the sum of the amounts of each asset in a request must be 0,
+// such as the transfers split among the parties,
+// the fee returned by Context.Fee is counted as credited to the fee account.
+func (m *Meta) SetZeroSum(zeroSum bool) {
+	m.mu.Lock()
+	m.zeroSum = zeroSum
+	m.mu.Unlock()
+}
+
+// ZeroSum reports whether the sum of the amounts of each asset in a request must be 0.
+func (m *Meta) ZeroSum() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.zeroSum
+}
+
+// AllowTransition declares the transitions from a status to the others,
+// the UnsetCode is the status of the new orders.
+// Once declared, only the declared transitions are allowed,
+// and the Step rules are still applied.
+func (m *Meta) AllowTransition(from int64, to ...int64) error {
+	for _, code := range append([]int64{from}, to...) {
+		if _, ok := m.statuses[code]; !ok {
+			return ErrUnknownStatus.With("opay.unknown_status.detail", m.orderType, code)
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.graph == nil {
+		m.graph = make(map[int64]map[int64]bool)
+	}
+	if m.graph[from] == nil {
+		m.graph[from] = make(map[int64]bool)
+	}
+	for _, code := range to {
+		m.graph[from][code] = true
+	}
+	return nil
+}
+
+// Transitions returns the declared transitions from the status, in ascending order of the codes.
+func (m *Meta) Transitions(from int64) []int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	codes := make([]int64, 0, len(m.graph[from]))
+	for code := range m.graph[from] {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+	return codes
+}
+
+// Checks whether the transition is allowed by the declared graph.
+func (m *Meta) checkTransition(from, to int64) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if len(m.graph) == 0 || m.graph[from][to] {
+		return nil
+	}
+	return ErrInvalidTransition.With("opay.invalid_transition.detail",
+		m.orderType, m.describe(from), m.describe(to))
+}
+
+// Describes the status, such as 10(pend, PEND).
+func (m *Meta) describe(code int64) string {
+	status := m.statuses[code]
+	if len(status.Note) == 0 {
+		return fmt.Sprintf("%d(%s)", code, status.Step)
+	}
+	return fmt.Sprintf("%d(%s, %s)", code, status.Note, status.Step)
+}
+
+// DOT exports the declared transition graph in Graphviz DOT language.
+func (m *Meta) DOT() string {
+	codes := make([]int64, 0, len(m.statuses))
+	for code := range m.statuses {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "digraph %q {\n", m.orderType)
+	for _, code := range codes {
+		if code == m.unsetCode {
+			fmt.Fprintf(&buf, "\t%q [shape=point];\n", strconv.FormatInt(code, 10))
+			continue
+		}
+		status := m.statuses[code]
+		label := strconv.FormatInt(code, 10)
+		if len(status.Note) > 0 {
+			label += " " + status.Note
+		}
+		fmt.Fprintf(&buf, "\t%q [label=%q];\n", strconv.FormatInt(code, 10), label+"\n"+status.Step.String())
+	}
+	for _, from := range codes {
+		for _, to := range m.Transitions(from) {
+			fmt.Fprintf(&buf, "\t%q -> %q;\n", strconv.FormatInt(from, 10), strconv.FormatInt(to, 10))
+		}
+	}
+	buf.WriteString("}\n")
+	return buf.String()
+}
+
+// Use appends the middlewares of the order type,
+// which run inside the ones of Opay.Use.
+func (m *Meta) Use(middleware ...Middleware) {
+	m.mu.Lock()
+	m.middlewares = append(m.middlewares[:len(m.middlewares):len(m.middlewares)], middleware...)
+	m.mu.Unlock()
+}
+
 // Execute order processing
-func (m *Meta) serve(ctx *Context) error {
-	// If the structure type, then create a new instance
+func (m *Meta) serve(ctx *Context, middlewares []Middleware) error {
+	var handler Handler
+	// If the structure type, then create a new instance for each request
 	if m.handler.Kind() == reflect.Struct {
-		m.handler = reflect.New(m.handler.Type())
+		handler = reflect.New(m.handler.Type()).Interface().(Handler)
+	} else {
+		handler = m.handler.Interface().(Handler)
 	}
-	return m.handler.Interface().(Handler).ServeOpay(ctx)
+	m.mu.RLock()
+	own := m.middlewares
+	m.mu.RUnlock()
+	return chain(handler, middlewares, own).ServeOpay(ctx)
 }
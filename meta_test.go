@@ -0,0 +1,72 @@
+package opay
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMetaStepStatus(t *testing.T) {
+	_, meta := newTestOpay(t, 1)
+	status, ok := meta.StepStatus(CANCEL)
+	if !ok || status.Code != 3 {
+		t.Fatalf("cancel status: %+v %v", status, ok)
+	}
+	if _, ok = meta.StepStatus(FAIL); ok {
+		t.Fatal("unregistered fail status")
+	}
+	if _, ok = meta.StepStatus(UNSET); ok {
+		t.Fatal("unset status is found")
+	}
+}
+
+func TestMetaTransition(t *testing.T) {
+	o, meta := newTestOpay(t, 1)
+	if err := meta.AllowTransition(meta.UnsetCode(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := meta.AllowTransition(1, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := meta.AllowTransition(1, 9); err == nil {
+		t.Fatal("unregistered status is allowed")
+	}
+
+	req := newTestRequest(meta, "a", 1)
+	if _, err := req.prepare(o); err != nil {
+		t.Fatal(err)
+	}
+	order := req.Initiator.(*testOrder)
+	order.pre, order.target = 1, 2
+	_, err := req.prepare(o)
+	if !errors.Is(err, ErrInvalidTransition) {
+		t.Fatalf("undeclared transition: %v", err)
+	}
+	t.Log(err)
+
+	dot := meta.DOT()
+	if !strings.Contains(dot, `"1" -> "3";`) || strings.Contains(dot, `"1" -> "2";`) {
+		t.Fatalf("dot:\n%s", dot)
+	}
+}
+
+func TestMetaRegistry(t *testing.T) {
+	o, meta := newTestOpay(t, 1)
+	if m, ok := o.Meta("test"); !ok || m != meta {
+		t.Fatal("meta is not found")
+	}
+	if o.MetaStep("test", 2) != SUCCEED || o.MetaNote("test", 3) != "cancel" {
+		t.Fatal("wrong status")
+	}
+	if statuses := meta.Statuses(); len(statuses) != 3 || statuses[0].Code != 1 {
+		t.Fatalf("statuses: %+v", statuses)
+	}
+
+	if !o.UnregMeta("test") || len(o.Metas()) != 0 {
+		t.Fatal("unregister")
+	}
+	req := newTestRequest(meta, "a", 1)
+	if _, err := req.prepare(o); err != ErrUnknownMeta {
+		t.Fatalf("unregistered meta: %v", err)
+	}
+}
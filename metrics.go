@@ -0,0 +1,188 @@
+package opay
+
+import (
+	"errors"
+	"expvar"
+	"sync"
+	"time"
+)
+
+type (
+	// Instrumentation receives the runtime measurements of opay, such as a metrics exporter.
+	Instrumentation interface {
+		// Enqueued is called after pushing a request, with the waiting time.
+		Enqueued(orderType string, wait time.Duration)
+		// Handled is called after handling a request.
+		Handled(orderType string, step Step, duration time.Duration, err error)
+		// Finished is called after committing or rolling back the transaction owned by opay,
+		// or releasing or rolling back the savepoint of the caller's transaction.
+		Finished(orderType string, committed bool)
+		// Workers is called when the number of the active workers changes.
+		Workers(active, max int)
+	}
+
+	// Stats is a snapshot of the runtime statistics.
+	Stats struct {
+		QueueLen      int                     `json:"queue_len"`
+		QueueCap      int                     `json:"queue_cap"`
+		ActiveWorkers int                     `json:"active_workers"`
+		MaxWorkers    int                     `json:"max_workers"`
+		Enqueued      int64                   `json:"enqueued"`
+		EnqueueWait   time.Duration           `json:"enqueue_wait"` //total waiting time of pushing
+		Commits       int64                   `json:"commits"`      //including the requests released to the caller's transaction
+		Rollbacks     int64                   `json:"rollbacks"`
+		Retries       int64                   `json:"retries"`
+		Errors        map[string]int64        `json:"errors"`   //counts by the Key of *Error, or the message of the other errors
+		Handlers      map[string]HandlerStats `json:"handlers"` //by '<order type>/<step>'
+	}
+
+	// HandlerStats is the statistics of the handler of an order type and step.
+	HandlerStats struct {
+		Count  int64         `json:"count"`
+		Errors int64         `json:"errors"`
+		Total  time.Duration `json:"total"`
+		Max    time.Duration `json:"max"`
+	}
+
+	// Collects the statistics, and forwards to the instrumentation.
+	metrics struct {
+		stats Stats
+		inst  Instrumentation
+		mu    sync.Mutex
+	}
+)
+
+const (
+	MAX_STATS_ERRORS   = 100     // MAX_STATS_ERRORS is the max number of distinct errors counted, the others are counted as STATS_OTHER_ERRORS
+	STATS_OTHER_ERRORS = "other" // STATS_OTHER_ERRORS is the key of the errors beyond MAX_STATS_ERRORS
+)
+
+// SetInstrumentation sets the receiver of the runtime measurements,
+// it must be called before starting.
+func (opay *Opay) SetInstrumentation(inst Instrumentation) error {
+	opay.stateMu.Lock()
+	defer opay.stateMu.Unlock()
+	if opay.started {
+		return ErrStarted
+	}
+	opay.metrics.inst = inst
+	return nil
+}
+
+// Stats returns a snapshot of the runtime statistics.
+func (opay *Opay) Stats() Stats {
+	opay.metrics.mu.Lock()
+	stats := opay.metrics.stats
+	stats.Errors = make(map[string]int64, len(opay.metrics.stats.Errors))
+	for k, v := range opay.metrics.stats.Errors {
+		stats.Errors[k] = v
+	}
+	stats.Handlers = make(map[string]HandlerStats, len(opay.metrics.stats.Handlers))
+	for k, v := range opay.metrics.stats.Handlers {
+		stats.Handlers[k] = v
+	}
+	opay.metrics.mu.Unlock()
+
+	stats.QueueLen = opay.queue.Len()
+	stats.QueueCap = opay.queue.GetCap()
+	return stats
+}
+
+// PublishExpvar publishes the statistics through expvar with the name,
+// it panics if the name is used.
+func (opay *Opay) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return opay.Stats()
+	}))
+}
+
+func (m *metrics) enqueued(orderType string, wait time.Duration) {
+	m.mu.Lock()
+	m.stats.Enqueued++
+	m.stats.EnqueueWait += wait
+	m.mu.Unlock()
+	if m.inst != nil {
+		m.inst.Enqueued(orderType, wait)
+	}
+}
+
+func (m *metrics) handled(orderType string, step Step, duration time.Duration, err error) {
+	key := orderType + "/" + step.String()
+	m.mu.Lock()
+	h := m.stats.Handlers[key]
+	h.Count++
+	h.Total += duration
+	if duration > h.Max {
+		h.Max = duration
+	}
+	if err != nil {
+		h.Errors++
+		if m.stats.Errors == nil {
+			m.stats.Errors = make(map[string]int64)
+		}
+		key := errorKey(err)
+		if _, ok := m.stats.Errors[key]; !ok && len(m.stats.Errors) >= MAX_STATS_ERRORS {
+			key = STATS_OTHER_ERRORS
+		}
+		m.stats.Errors[key]++
+	}
+	if m.stats.Handlers == nil {
+		m.stats.Handlers = make(map[string]HandlerStats)
+	}
+	m.stats.Handlers[key] = h
+	m.mu.Unlock()
+	if m.inst != nil {
+		m.inst.Handled(orderType, step, duration, err)
+	}
+}
+
+// Returns the Key of the typed error, which does not vary with the details and the language,
+// or the message of the untyped error.
+func errorKey(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Key
+	}
+	return err.Error()
+}
+
+func (m *metrics) finished(orderType string, committed bool) {
+	m.mu.Lock()
+	if committed {
+		m.stats.Commits++
+	} else {
+		m.stats.Rollbacks++
+	}
+	m.mu.Unlock()
+	if m.inst != nil {
+		m.inst.Finished(orderType, committed)
+	}
+}
+
+func (m *metrics) retried() {
+	m.mu.Lock()
+	m.stats.Retries++
+	m.mu.Unlock()
+}
+
+// Adds delta to the active workers, and sets the max workers if max > 0.
+func (m *metrics) workers(delta, max int) {
+	m.mu.Lock()
+	m.stats.ActiveWorkers += delta
+	if max > 0 {
+		m.stats.MaxWorkers = max
+	}
+	active, max := m.stats.ActiveWorkers, m.stats.MaxWorkers
+	m.mu.Unlock()
+	if m.inst != nil {
+		m.inst.Workers(active, max)
+	}
+}
+
+// Returns the order type of the request, which may be not prepared.
+func orderTypeOf(req *Request) string {
+	if req.Initiator == nil || req.Initiator.GetMeta() == nil {
+		return ""
+	}
+	return req.Initiator.GetMeta().OrderType()
+}
@@ -0,0 +1,37 @@
+package opay
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	o, meta := newTestOpay(t, 10)
+	o.queue.Push(newTestRequest(meta, "a", 1))
+	o.metrics.workers(0, 2)
+	o.metrics.workers(1, 0)
+	o.metrics.handled("test", PEND, time.Millisecond, nil)
+	o.metrics.handled("test", PEND, 3*time.Millisecond, ErrIncorrectAmount)
+	o.metrics.handled("test", SUCCEED, 0, ErrIncorrectAmount.Wrap(errors.New("order 1: -0.01")))
+	o.metrics.finished("test", true)
+
+	stats := o.Stats()
+	if stats.QueueLen != 1 || stats.QueueCap != 10 || stats.ActiveWorkers != 1 || stats.MaxWorkers != 2 {
+		t.Fatalf("stats: %+v", stats)
+	}
+	h := stats.Handlers["test/PEND"]
+	if h.Count != 2 || h.Errors != 1 || h.Max != 3*time.Millisecond || h.Total != 4*time.Millisecond {
+		t.Fatalf("handler stats: %+v", h)
+	}
+	if stats.Commits != 1 || len(stats.Errors) != 1 || stats.Errors[ErrIncorrectAmount.Key] != 2 {
+		t.Fatalf("stats: %+v", stats)
+	}
+
+	for i := 0; i < MAX_STATS_ERRORS+1; i++ {
+		o.metrics.handled("test", PEND, 0, errors.New(time.Duration(i).String()))
+	}
+	if stats = o.Stats(); len(stats.Errors) != MAX_STATS_ERRORS+1 || stats.Errors[STATS_OTHER_ERRORS] != 2 {
+		t.Fatalf("errors: %d %d", len(stats.Errors), stats.Errors[STATS_OTHER_ERRORS])
+	}
+}
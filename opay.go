@@ -1,58 +1,246 @@
 package opay
 
 import (
-	"fmt"
+	"context"
+	"database/sql"
 	"sync"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 )
 
 type Opay struct {
+	savepoints     uint64 //sequence of the savepoint names, first for the 64-bit alignment
 	metas          map[string]*Meta
 	queue          Queue    //request queue
 	db             *sqlx.DB //global database operation instance
 	*SettleFuncMap          //global map of SettleFunc
 	*Floater
 	metasLock sync.RWMutex
+
+	started     bool
+	serial      *accountSequencer //serializes the requests of the same account if not nil
+	idempotency IdempotencyStore  //the optional, stores the succeeded requests with idempotency keys
+	journal     Journal           //the optional, records the balance changes
+	fees        FeeEngine         //the optional, calculates the fees
+	feeUid      string            //uid of the fee accounts
+	quotes      *QuoteBook        //the optional, checks the exchanges against the quotes
+	retry       *RetryPolicy      //the optional, retries the requests failed with the transient errors
+	middlewares []Middleware      //wrap the handlers of all the order types
+	events      eventBus
+	eventWriter EventWriter //the optional, writes the events in the transaction
+	metrics     metrics
+	maxWorkers  int                      //max number of the concurrent handlers, 1/5 of the queue capacity if not set
+	limits      map[string]chan struct{} //concurrency limits per order type
+	txOptions   *sql.TxOptions           //options of the transactions owned by opay
+	optionErr   error                    //the error of the options, returned by Start
+	logger      Logger
+	clock       func() time.Time
+	stateMu     sync.Mutex
+	handling    sync.WaitGroup //in-flight handlers
+	done        chan struct{}  //closed when the serving loop exits
 }
 
+// NewOpay creates an Opay with the global SettleFuncMap, see New for more options.
 func NewOpay(db *sqlx.DB, queueCapacity int, numOfDecimalPlaces int) *Opay {
-	opay := &Opay{
-		SettleFuncMap: globalSettleFuncMap,
-		db:            db,
-		metas:         make(map[string]*Meta),
-		Floater:       NewFloater(numOfDecimalPlaces),
-	}
-	opay.queue = newOrderChan(queueCapacity, opay)
-	return opay
+	return New(db, WithQueueCapacity(queueCapacity), WithDecimalPlaces(numOfDecimalPlaces))
 }
 
 // 处理请求
 func (opay *Opay) Do(req Request) *Response {
-	return <-opay.queue.Push(req)
+	start := time.Now()
+	respChan := opay.queue.Push(req)
+	opay.metrics.enqueued(orderTypeOf(&req), time.Since(start))
+	return <-respChan
+}
+
+// DoContext handles the request within ctx.
+// The cancellation and deadline of ctx abort the queue waiting,
+// the transaction and the handler.
+func (opay *Opay) DoContext(ctx context.Context, req Request) *Response {
+	if d, ok := ctx.Deadline(); ok && (req.Deadline.IsZero() || d.Before(req.Deadline)) {
+		req.Deadline = d
+	}
+	return opay.Do(req.WithContext(ctx))
 }
 
 func (opay *Opay) DB() *sqlx.DB {
 	return opay.db
 }
 
-// Opay start.
-func (opay *Opay) Serve() {
+// Reports whether the amount is not zero,
+// and has no more decimal places than numOfDecimalPlaces.
+func (opay *Opay) validAmount(amount Amount) bool {
+	if amount.IsZero() {
+		return false
+	}
+	decimals := opay.NumOfDecimalPlaces()
+	return amount.Scale() <= decimals || amount.Equal(amount.Round(decimals))
+}
+
+// SetQueue replaces the request queue, it must be called before starting.
+func (opay *Opay) SetQueue(queue Queue) error {
+	opay.stateMu.Lock()
+	defer opay.stateMu.Unlock()
+	if opay.started {
+		return ErrStarted
+	}
+	if queue.GetOpay() != opay {
+		return ErrQueueOwner
+	}
+	opay.queue = queue
+	return nil
+}
+
+// Serve starts Opay and blocks until it is shut down.
+func (opay *Opay) Serve() error {
+	if err := opay.Start(); err != nil {
+		return err
+	}
+	return opay.Wait()
+}
+
+// SetSerialAccounts sets whether the requests touching the same Uid-Aid account
+// are processed one by one in order, while the others still run in parallel.
+// It must be called before starting.
+func (opay *Opay) SetSerialAccounts(enable bool) error {
+	opay.stateMu.Lock()
+	defer opay.stateMu.Unlock()
+	if opay.started {
+		return ErrStarted
+	}
+	if enable {
+		opay.serial = newAccountSequencer()
+	} else {
+		opay.serial = nil
+	}
+	return nil
+}
+
+// SetIdempotencyStore sets the store of the idempotency keys,
+// it must be called before starting.
+func (opay *Opay) SetIdempotencyStore(store IdempotencyStore) error {
+	opay.stateMu.Lock()
+	defer opay.stateMu.Unlock()
+	if opay.started {
+		return ErrStarted
+	}
+	opay.idempotency = store
+	return nil
+}
+
+// Use appends the middlewares wrapping the handlers of all the order types,
+// which run in the transaction. It must be called before starting.
+func (opay *Opay) Use(middleware ...Middleware) error {
+	opay.stateMu.Lock()
+	defer opay.stateMu.Unlock()
+	if opay.started {
+		return ErrStarted
+	}
+	opay.middlewares = append(opay.middlewares, middleware...)
+	return nil
+}
+
+// SetJournal sets the journal recording the balance changes,
+// it must be called before starting.
+func (opay *Opay) SetJournal(journal Journal) error {
+	opay.stateMu.Lock()
+	defer opay.stateMu.Unlock()
+	if opay.started {
+		return ErrStarted
+	}
+	opay.journal = journal
+	return nil
+}
+
+// Posts the balance changes to the journal if set.
+func (opay *Opay) post(tx *sqlx.Tx, posting *Posting) error {
+	if opay.journal == nil || len(posting.Changes) == 0 {
+		return nil
+	}
+	return opay.journal.Post(tx, posting)
+}
+
+// Start checks the database, and starts processing the queued requests in background.
+func (opay *Opay) Start() error {
+	opay.stateMu.Lock()
+	defer opay.stateMu.Unlock()
+	if opay.started {
+		return ErrStarted
+	}
+	if opay.optionErr != nil {
+		return opay.optionErr
+	}
 	if err := opay.db.Ping(); err != nil {
-		panic(err)
+		return err
+	}
+	opay.started = true
+	go opay.serve()
+	return nil
+}
+
+// Shutdown stops accepting new requests, then waits for the queued and in-flight ones
+// to be committed or rolled back, until ctx is done.
+func (opay *Opay) Shutdown(ctx context.Context) error {
+	opay.stateMu.Lock()
+	started := opay.started
+	opay.stateMu.Unlock()
+	opay.queue.Close()
+	if !started {
+		return ErrNotStarted
+	}
+	select {
+	case <-opay.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Wait blocks until Opay is shut down and all requests are finished,
+// it returns ErrNotStarted at once if Opay has not been started.
+func (opay *Opay) Wait() error {
+	opay.stateMu.Lock()
+	started := opay.started
+	opay.stateMu.Unlock()
+	if !started {
+		return ErrNotStarted
+	}
+	<-opay.done
+	return nil
+}
+
+// Processing loop.
+func (opay *Opay) serve() {
+	defer close(opay.done)
+
+	var maxRoutine = opay.maxWorkers
+	if maxRoutine <= 0 {
+		maxRoutine = opay.queue.GetCap() / 5
 	}
-	var maxRoutine = opay.queue.GetCap() / 5
 	if maxRoutine == 0 {
 		maxRoutine = 1
 	}
 	var src = make(chan struct{}, maxRoutine)
+	opay.metrics.workers(0, maxRoutine)
+
+	// Limits the requests pulled and not finished, including the ones waiting for
+	// their turn of the accounts or the concurrency limit of their order type,
+	// which don't hold the worker slots.
+	var maxPending = opay.queue.GetCap()
+	if maxPending < maxRoutine {
+		maxPending = maxRoutine
+	}
+	var pending = make(chan struct{}, maxPending)
 	for {
-		// Gets an execute permission
-		src <- struct{}{}
+		pending <- struct{}{}
 
 		// Read a request
-		// Unlimited wait
-		req := opay.queue.Pull()
+		// Wait until the queue is closed and drained
+		req, ok := opay.queue.Pull()
+		if !ok {
+			break
+		}
 
 		var err error
 
@@ -67,6 +255,7 @@ func (opay *Opay) Serve() {
 			// Returns if the operation interface of the specified asset account does not exist.
 			req.setError(err)
 			req.writeback()
+			<-pending
 			continue
 		}
 		if req.Stakeholder != nil {
@@ -75,47 +264,167 @@ func (opay *Opay) Serve() {
 				// Returns if the operation interface of the specified asset account does not exist
 				req.setError(err)
 				req.writeback()
+				<-pending
 				continue
 			}
 		}
+		partySettles := make([]SettleFunc, len(req.Parties))
+		for i, party := range req.Parties {
+			partySettles[i], err = opay.GetSettleFunc(party.GetAid())
+			if err != nil {
+				break
+			}
+		}
+		if err != nil {
+			// Returns if the operation interface of the specified asset account does not exist
+			req.setError(err)
+			req.writeback()
+			<-pending
+			continue
+		}
+
+		// Keeps the order of the requests touching the same account.
+		var wait, leave = func() {}, func() {}
+		if opay.serial != nil {
+			wait, leave = opay.serial.enter(req.accounts())
+		}
 
 		// The order processing is performed by routing.
+		opay.handling.Add(1)
 		go func() {
-			var err error
 			defer func() {
-				r := recover()
-				if r != nil {
-					err = fmt.Errorf("opay panic: %v", r)
-				}
-
-				// Close the request, and mark the end of the request processing
-				req.setError(err)
-				req.writeback()
+				leave()
+				<-pending
+				opay.handling.Done()
+			}()
+			wait()
+			// Keeps the concurrency limit of the order type.
+			if limit := opay.limits[req.Operator()]; limit != nil {
+				limit <- struct{}{}
+				defer func() { <-limit }()
+			}
+			// Gets an execute permission
+			src <- struct{}{}
+			opay.metrics.workers(1, 0)
+			defer func() {
 				// Frees an execute permission
 				<-src
+				opay.metrics.workers(-1, 0)
 			}()
+			start := time.Now()
+			err := opay.handleRetry(req, initiatorSettle, stakeholderSettle, partySettles)
+			opay.metrics.handled(req.Operator(), req.Step(), time.Since(start), err)
+
+			// Close the request, and mark the end of the request processing
+			req.setError(err)
+			req.writeback()
+		}()
+	}
+
+	// Waiting for the in-flight handlers to commit or roll back.
+	opay.handling.Wait()
+	// Waiting for the asynchronous event deliveries.
+	opay.events.wg.Wait()
+}
 
-			if req.Tx == nil {
-				req.Tx, err = opay.db.Beginx()
-				if err != nil {
-					return
-				}
-				defer func() {
-					if err != nil {
-						req.Tx.Rollback()
-					} else {
-						req.Tx.Commit()
-					}
-				}()
+// Handles a request in the transaction,
+// which is committed or rolled back if it is owned by opay,
+// otherwise the writes of the failed request are rolled back to a savepoint.
+// committing reports whether err is returned by committing the transaction.
+func (opay *Opay) handle(req Request, initiatorSettle, stakeholderSettle SettleFunc, partySettles []SettleFunc) (committing bool, err error) {
+	// Returns if the caller has gone.
+	if err = req.Context().Err(); err != nil {
+		return
+	}
+
+	// Returns the original response if the request has succeeded.
+	var record *IdempotencyRecord
+	if len(req.IdempotencyKey) > 0 && opay.idempotency != nil {
+		record = req.idempotencyRecord(opay.Now())
+		replayed, err := opay.replayed(record)
+		if replayed {
+			req.response.setReplayed()
+		}
+		if replayed || err != nil {
+			return false, err
+		}
+	}
+
+	var sp *savepoint
+	owned := req.Tx == nil
+	if owned {
+		req.Tx, err = opay.db.BeginTxx(req.Context(), opay.txOptions)
+	} else {
+		sp, err = opay.savepoint(req.Tx)
+	}
+	if err != nil {
+		return
+	}
+	defer func() {
+		r := recover()
+		if r != nil {
+			err = ErrPanic.With("opay.panic.detail", r)
+		}
+		if sp != nil {
+			if err == nil {
+				err = sp.release()
+			} else if e := sp.rollback(); e != nil {
+				opay.logger.Printf("opay: rollback to savepoint %s: %v", sp.name, e)
 			}
+		}
+		if err != nil {
+			// Discards the events of the failed request.
+			req.response.takeEvents()
+		}
+		if !owned {
+			// The caller commits its Tx and publishes the events,
+			// and the writes of the failed request have been rolled back to the savepoint.
+			opay.metrics.finished(req.Operator(), err == nil)
+			err = opay.replayFailed(req, record, err)
+			return
+		}
+		if err != nil {
+			req.Tx.Rollback()
+		} else {
+			err = req.Tx.Commit()
+			committing = err != nil
+		}
+		opay.metrics.finished(req.Operator(), err == nil)
+		if err == nil {
+			opay.Publish(req.response.Events...)
+			return
+		}
+		req.response.takeEvents()
+		err = opay.replayFailed(req, record, err)
+	}()
 
-			err = req.Initiator.GetMeta().serve(&Context{
-				initiatorSettle:   initiatorSettle,
-				stakeholderSettle: stakeholderSettle,
-				Request:           req,
-				Response:          req.response,
-				Floater:           opay.Floater,
-			})
-		}()
+	ctx := &Context{
+		initiatorSettle:   initiatorSettle,
+		stakeholderSettle: stakeholderSettle,
+		partySettles:      partySettles,
+		opay:              opay,
+		Request:           req,
+		Response:          req.response,
+		Floater:           opay.Floater,
+	}
+	err = req.Initiator.GetMeta().serve(ctx, opay.middlewares)
+	if err == nil && req.Initiator.GetMeta().ZeroSum() {
+		var ok bool
+		if ok, err = ctx.zeroSum(); err == nil && !ok {
+			err = ErrNotZeroSum
+		}
+	}
+	if err == nil {
+		ctx.emitStatusChanges()
+		if opay.eventWriter != nil {
+			err = opay.eventWriter.WriteEvents(req.Tx, req.response.Events)
+		}
+	}
+	if err == nil && record != nil {
+		err = opay.idempotency.Put(req.Tx, record)
+	}
+	if err == nil && req.ack != nil {
+		err = req.ack(req.Tx)
 	}
+	return
 }
@@ -0,0 +1,151 @@
+package opay
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type (
+	// Option configures the Opay created by New.
+	Option func(*Opay)
+
+	// Logger prints the errors which can not be returned, *log.Logger is a Logger.
+	Logger interface {
+		Printf(format string, v ...interface{})
+	}
+
+	// Prints with the standard logger.
+	stdLogger struct{}
+)
+
+const (
+	DEFAULT_DECIMAL_PLACES = 2 // DEFAULT_DECIMAL_PLACES is the default number of decimal places of the amounts
+)
+
+// New creates an Opay with the options,
+// the defaults are the same as NewOpay(db, DEFAULT_QUEUE_CAP, DEFAULT_DECIMAL_PLACES).
+func New(db *sqlx.DB, opts ...Option) *Opay {
+	opay := &Opay{
+		SettleFuncMap: globalSettleFuncMap,
+		db:            db,
+		metas:         make(map[string]*Meta),
+		Floater:       NewFloater(DEFAULT_DECIMAL_PLACES),
+		logger:        stdLogger{},
+		clock:         time.Now,
+		done:          make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(opay)
+	}
+	if opay.queue == nil {
+		opay.queue = newOrderChan(DEFAULT_QUEUE_CAP, opay)
+	}
+	return opay
+}
+
+// WithQueueCapacity sets the capacity of the default queue.
+func WithQueueCapacity(queueCapacity int) Option {
+	return func(opay *Opay) {
+		opay.queue = newOrderChan(queueCapacity, opay)
+	}
+}
+
+// WithDecimalPlaces sets the number of decimal places of the amounts, between 0 and 14.
+func WithDecimalPlaces(numOfDecimalPlaces int) Option {
+	return func(opay *Opay) {
+		opay.Floater = NewFloater(numOfDecimalPlaces)
+	}
+}
+
+// WithQueue sets the queue created by newQueue, such as:
+//
+//	opay.CreateDBQueueTable(db, "opay_queue")
+//	o := opay.New(db, opay.WithQueue(func(o *opay.Opay) (opay.Queue, error) {
+//		return opay.NewDBQueue(o, "opay_queue", codec, hostname)
+//	}))
+//	if err := o.Start(); err != nil {
+//		// The error of newQueue is returned here.
+//	}
+//
+// The queue must belong to the Opay, otherwise Start returns ErrQueueOwner.
+func WithQueue(newQueue func(*Opay) (Queue, error)) Option {
+	return func(opay *Opay) {
+		queue, err := newQueue(opay)
+		if err == nil && (queue == nil || queue.GetOpay() != opay) {
+			err = ErrQueueOwner
+		}
+		if err != nil {
+			opay.optionErr = err
+			return
+		}
+		opay.queue = queue
+	}
+}
+
+// WithMaxWorkers sets the max number of the requests handled concurrently,
+// which is 1/5 of the queue capacity by default.
+func WithMaxWorkers(maxWorkers int) Option {
+	return func(opay *Opay) {
+		opay.maxWorkers = maxWorkers
+	}
+}
+
+// WithConcurrency limits the number of the requests of the order type handled concurrently,
+// which still count in the max workers.
+// The requests waiting for the limit don't hold the worker slots, so the other order types go on.
+func WithConcurrency(orderType string, limit int) Option {
+	return func(opay *Opay) {
+		if limit <= 0 {
+			delete(opay.limits, orderType)
+			return
+		}
+		if opay.limits == nil {
+			opay.limits = make(map[string]chan struct{})
+		}
+		opay.limits[orderType] = make(chan struct{}, limit)
+	}
+}
+
+// WithTxOptions sets the options of the transactions owned by opay,
+// such as the isolation level.
+func WithTxOptions(txOptions *sql.TxOptions) Option {
+	return func(opay *Opay) {
+		opay.txOptions = txOptions
+	}
+}
+
+// WithLogger sets the logger, which is the standard logger by default.
+func WithLogger(logger Logger) Option {
+	return func(opay *Opay) {
+		opay.logger = logger
+	}
+}
+
+// WithClock sets the function returning the current time,
+// which is used for the timestamps and the expiration of the orders.
+func WithClock(clock func() time.Time) Option {
+	return func(opay *Opay) {
+		opay.clock = clock
+	}
+}
+
+// WithSettleFuncMap sets the SettleFunc and Freezer registry of the instance,
+// instead of the global one.
+func WithSettleFuncMap(settleFuncMap *SettleFuncMap) Option {
+	return func(opay *Opay) {
+		opay.SettleFuncMap = settleFuncMap
+	}
+}
+
+// Now returns the current time of the clock.
+func (opay *Opay) Now() time.Time {
+	return opay.clock()
+}
+
+// Printf implements Logger.
+func (stdLogger) Printf(format string, v ...interface{}) {
+	log.Printf(format, v...)
+}
@@ -0,0 +1,118 @@
+package opay
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestNewOptions(t *testing.T) {
+	o := New(nil)
+	if o.queue.GetCap() != DEFAULT_QUEUE_CAP || o.NumOfDecimalPlaces() != DEFAULT_DECIMAL_PLACES || o.SettleFuncMap != globalSettleFuncMap {
+		t.Fatalf("defaults: cap %d, decimals %d", o.queue.GetCap(), o.NumOfDecimalPlaces())
+	}
+
+	now := time.Unix(1500000000, 0)
+	settles := NewSettleFuncMap()
+	o = New(nil,
+		WithQueueCapacity(10),
+		WithDecimalPlaces(4),
+		WithMaxWorkers(3),
+		WithConcurrency("test", 1),
+		WithConcurrency("other", 0),
+		WithTxOptions(&sql.TxOptions{Isolation: sql.LevelSerializable}),
+		WithClock(func() time.Time { return now }),
+		WithSettleFuncMap(settles),
+	)
+	if o.queue.GetCap() != 10 || o.NumOfDecimalPlaces() != 4 || o.maxWorkers != 3 {
+		t.Fatalf("options: cap %d, decimals %d, workers %d", o.queue.GetCap(), o.NumOfDecimalPlaces(), o.maxWorkers)
+	}
+	if cap(o.limits["test"]) != 1 || o.limits["other"] != nil {
+		t.Fatalf("limits: %v", o.limits)
+	}
+	if o.txOptions.Isolation != sql.LevelSerializable || !o.Now().Equal(now) {
+		t.Fatalf("tx options %v, now %s", o.txOptions, o.Now())
+	}
+
+	// The per-instance registry is independent of the global one.
+	if err := o.RegSettleFunc("9", emptySettle); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := globalSettleFuncMap.GetSettleFunc("9"); err == nil {
+		t.Fatal("registered to the global map")
+	}
+	if _, err := o.GetSettleFunc(""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWithQueue(t *testing.T) {
+	o := New(nil, WithQueue(func(o *Opay) (Queue, error) { return newOrderChan(7, o), nil }))
+	if o.queue.GetCap() != 7 || o.optionErr != nil {
+		t.Fatalf("queue cap: %d, %v", o.queue.GetCap(), o.optionErr)
+	}
+
+	other := New(nil)
+	o = New(nil, WithQueue(func(*Opay) (Queue, error) { return other.queue, nil }))
+	if err := o.Start(); err != ErrQueueOwner {
+		t.Fatalf("queue of another Opay: %v", err)
+	}
+	o = New(nil, WithQueue(func(o *Opay) (Queue, error) { return NewDBQueue(o, "opay_queue", nil, "a") }))
+	if err := o.Start(); err != ErrQueueCodec {
+		t.Fatalf("failed queue: %v", err)
+	}
+}
+
+func TestWithConcurrency(t *testing.T) {
+	// The handlers hold the connections concurrently.
+	db, err := sqlx.Open("sqlite3", filepath.Join(t.TempDir(), "opay.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	started, release := make(chan struct{}), make(chan struct{})
+	o := New(db, WithMaxWorkers(2), WithConcurrency("slow", 1), WithSettleFuncMap(newTestSettles()))
+	statuses := []Status{{Code: 1, Note: "pend", Step: PEND}}
+	slow, err := o.RegMeta("slow", HandlerFunc(func(*Context) error {
+		started <- struct{}{}
+		<-release
+		return nil
+	}), statuses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fast, err := o.RegMeta("fast", HandlerFunc(func(*Context) error { return nil }), statuses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	startTestOpay(t, o)
+
+	// The second slow request waits for the limit without holding a worker slot.
+	var slowResps []<-chan *Response
+	for _, uid := range []string{"a", "b"} {
+		slowResps = append(slowResps, o.queue.Push(newTestRequest(slow, uid, 1)))
+	}
+	<-started
+	done := make(chan error)
+	go func() { done <- o.Do(newTestRequest(fast, "c", 1)).Err }()
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		close(release)
+		t.Fatal("the fast request is blocked by the slow ones")
+	}
+
+	close(release)
+	<-started
+	for _, resp := range slowResps {
+		if err = (<-resp).Err; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// Package outbox is a transactional outbox of opay.
+// The messages are written in the request's transaction, so they are exactly as durable as the orders,
+// then the Relay hands them to a Publisher in order per aggregate, retrying on failure.
+//
+//	store := outbox.New(db, "opay_outbox")
+//	store.CreateTable()
+//	opay.SetEventWriter(store.EventWriter("opay.order"))
+//	go outbox.NewRelay(store, publisher).Run(ctx)
+//
+// The delivery is at-least-once, the consumers should deduplicate by Message.Id.
+package outbox
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/henrylee2cn/opay"
+	"github.com/jmoiron/sqlx"
+)
+
+type (
+	// Message is a row of the outbox.
+	Message struct {
+		Id        int64  `json:"id" db:"id"`
+		Aggregate string `json:"aggregate" db:"aggregate"` //the messages of the same aggregate are published in order
+		Topic     string `json:"topic" db:"topic"`
+		Payload   []byte `json:"payload" db:"payload"`
+		Attempts  int    `json:"attempts" db:"attempts"`
+		NextAt    int64  `json:"next_at" db:"next_at"` //not published before it
+		SentAt    int64  `json:"sent_at" db:"sent_at"` //0 if not sent
+		LastError string `json:"last_error" db:"last_error"`
+		CreatedAt int64  `json:"created_at" db:"created_at"`
+	}
+
+	// Store is the outbox on a table.
+	Store struct {
+		db      *sqlx.DB
+		dialect opay.Dialect
+		table   string
+	}
+
+	// Writes the events of opay as messages.
+	eventWriter struct {
+		store *Store
+		topic string
+	}
+)
+
+const columns = "id, aggregate, topic, payload, attempts, next_at, sent_at, last_error, created_at"
+
+var _ opay.EventWriter = (*eventWriter)(nil)
+
+// New creates an outbox on the table.
+func New(db *sqlx.DB, table string) *Store {
+	return &Store{
+		db:      db,
+		dialect: opay.DialectOf(db.DriverName()),
+		table:   table,
+	}
+}
+
+// CreateTable creates the table if not exists.
+func (s *Store) CreateTable() error {
+	return s.dialect.Exec(s.db, s.dialect.CreateTable(s.table, []string{
+		"id " + s.dialect.AutoIncrementKey(),
+		"aggregate VARCHAR(128) NOT NULL",
+		"topic VARCHAR(128) NOT NULL",
+		"payload " + s.dialect.Blob() + " NOT NULL",
+		"attempts INT NOT NULL DEFAULT 0",
+		"next_at BIGINT NOT NULL DEFAULT 0",
+		"sent_at BIGINT NOT NULL DEFAULT 0",
+		"last_error VARCHAR(1024) NOT NULL DEFAULT ''",
+		"created_at BIGINT NOT NULL",
+	}, "sent_at, id"))
+}
+
+// Write writes the message in the transaction, and sets its Id.
+func (s *Store) Write(tx *sqlx.Tx, msg *Message) (err error) {
+	msg.CreatedAt = time.Now().Unix()
+	msg.Id, err = s.dialect.InsertId(tx,
+		"INSERT INTO "+s.table+" (aggregate, topic, payload, attempts, next_at, sent_at, last_error, created_at) VALUES (?, ?, ?, 0, 0, 0, '', ?)",
+		msg.Aggregate, msg.Topic, msg.Payload, msg.CreatedAt,
+	)
+	return
+}
+
+// EventWriter returns the opay.EventWriter writing the events as JSON messages of the topic,
+// whose aggregate is '<order type>:<order id>'.
+func (s *Store) EventWriter(topic string) opay.EventWriter {
+	return &eventWriter{store: s, topic: topic}
+}
+
+// WriteEvents implements opay.EventWriter.
+func (w *eventWriter) WriteEvents(tx *sqlx.Tx, events []opay.Event) error {
+	for _, event := range events {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		err = w.store.Write(tx, &Message{
+			Aggregate: event.OrderType + ":" + event.OrderId,
+			Topic:     w.topic,
+			Payload:   payload,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Purge deletes the messages sent before the time.
+func (s *Store) Purge(before time.Time) (int64, error) {
+	result, err := s.db.Exec(s.db.Rebind(
+		"DELETE FROM "+s.table+" WHERE sent_at > 0 AND sent_at < ?"),
+		before.Unix(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Returns the unsent messages in order,
+// skipping the aggregates whose messages wait for a retry after now.
+func (s *Store) unsent(limit int, now int64) ([]*Message, error) {
+	var msgs []*Message
+	err := s.db.Select(&msgs, s.db.Rebind(
+		"SELECT "+columns+" FROM "+s.table+" WHERE sent_at = 0 AND aggregate NOT IN ("+
+			"SELECT aggregate FROM "+s.table+" WHERE sent_at = 0 AND next_at > ?) ORDER BY id LIMIT ?"),
+		now, limit,
+	)
+	return msgs, err
+}
+
+// Marks the message as sent.
+func (s *Store) markSent(msg *Message) error {
+	_, err := s.db.Exec(s.db.Rebind(
+		"UPDATE "+s.table+" SET sent_at = ?, attempts = ? WHERE id = ?"),
+		msg.SentAt, msg.Attempts, msg.Id,
+	)
+	return err
+}
+
+// Saves the failed attempt.
+func (s *Store) retry(msg *Message) error {
+	_, err := s.db.Exec(s.db.Rebind(
+		"UPDATE "+s.table+" SET attempts = ?, next_at = ?, last_error = ? WHERE id = ?"),
+		msg.Attempts, msg.NextAt, msg.LastError, msg.Id,
+	)
+	return err
+}
@@ -0,0 +1,51 @@
+package outbox
+
+import (
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestStoreUnsent(t *testing.T) {
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	store := New(db, "opay_outbox")
+	if err = store.CreateTable(); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msgs []*Message
+	for _, aggregate := range []string{"a", "a", "b"} {
+		msg := &Message{Aggregate: aggregate, Topic: "t", Payload: []byte("{}")}
+		if err = store.Write(tx, msg); err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The message 1 waits for a retry until 100, so does the message 2.
+	msgs[0].Attempts, msgs[0].NextAt = 1, 100
+	if err = store.retry(msgs[0]); err != nil {
+		t.Fatal(err)
+	}
+	unsent, err := store.unsent(10, 99)
+	if err != nil || len(unsent) != 1 || unsent[0].Id != msgs[2].Id {
+		t.Fatalf("waiting: %v %v", unsent, err)
+	}
+	unsent, err = store.unsent(10, 100)
+	if err != nil || len(unsent) != 3 || unsent[0].Id != msgs[0].Id {
+		t.Fatalf("due: %v %v", unsent, err)
+	}
+}
@@ -0,0 +1,181 @@
+package outbox
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+type (
+	// Publisher publishes the messages to the message broker.
+	Publisher interface {
+		Publish(ctx context.Context, msg *Message) error
+	}
+
+	// PublisherFunc is a function of Publisher.
+	PublisherFunc func(ctx context.Context, msg *Message) error
+
+	// Relay hands the unsent messages to the Publisher, in order per aggregate.
+	// A failed message is retried with exponential backoff,
+	// and the later messages of its aggregate wait for it.
+	// Run only one Relay on a table to keep the order.
+	Relay struct {
+		source     source
+		publisher  Publisher
+		interval   time.Duration
+		batch      int
+		minBackoff time.Duration
+		maxBackoff time.Duration
+		mu         sync.RWMutex
+	}
+
+	// The messages to relay, implemented by Store.
+	source interface {
+		unsent(limit int, now int64) ([]*Message, error)
+		markSent(msg *Message) error
+		retry(msg *Message) error
+	}
+)
+
+const (
+	DEFAULT_RELAY_INTERVAL    = time.Second     // DEFAULT_RELAY_INTERVAL is the default polling interval
+	DEFAULT_RELAY_BATCH       = 100             // DEFAULT_RELAY_BATCH is the default number of messages read each time
+	DEFAULT_RELAY_MIN_BACKOFF = time.Second     // DEFAULT_RELAY_MIN_BACKOFF is the default delay of the first retry
+	DEFAULT_RELAY_MAX_BACKOFF = 5 * time.Minute // DEFAULT_RELAY_MAX_BACKOFF is the default max delay of the retries
+)
+
+var (
+	_ Publisher = PublisherFunc(nil)
+	_ source    = (*Store)(nil)
+)
+
+// Publish implements Publisher.
+func (fn PublisherFunc) Publish(ctx context.Context, msg *Message) error {
+	return fn(ctx, msg)
+}
+
+// NewRelay creates a relay of the store.
+func NewRelay(store *Store, publisher Publisher) *Relay {
+	return newRelay(store, publisher)
+}
+
+func newRelay(source source, publisher Publisher) *Relay {
+	return &Relay{
+		source:     source,
+		publisher:  publisher,
+		interval:   DEFAULT_RELAY_INTERVAL,
+		batch:      DEFAULT_RELAY_BATCH,
+		minBackoff: DEFAULT_RELAY_MIN_BACKOFF,
+		maxBackoff: DEFAULT_RELAY_MAX_BACKOFF,
+	}
+}
+
+// SetInterval sets the polling interval.
+func (r *Relay) SetInterval(interval time.Duration) {
+	r.mu.Lock()
+	r.interval = interval
+	r.mu.Unlock()
+}
+
+// SetBatch sets the number of messages read each time.
+func (r *Relay) SetBatch(batch int) {
+	r.mu.Lock()
+	r.batch = batch
+	r.mu.Unlock()
+}
+
+// SetBackoff sets the delay of the first retry, which doubles up to max.
+func (r *Relay) SetBackoff(min, max time.Duration) {
+	r.mu.Lock()
+	r.minBackoff, r.maxBackoff = min, max
+	r.mu.Unlock()
+}
+
+// Run relays the messages until ctx is done.
+func (r *Relay) Run(ctx context.Context) error {
+	for {
+		r.mu.RLock()
+		interval, batch := r.interval, r.batch
+		r.mu.RUnlock()
+
+		n, err := r.RelayOnce(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Println("opay: outbox relay:", err)
+		}
+		if err == nil && n >= batch {
+			// There may be more due ones.
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// RelayOnce reads a batch of the unsent messages of the aggregates not waiting for a retry,
+// and publishes them, returns the number of the published messages.
+func (r *Relay) RelayOnce(ctx context.Context) (int, error) {
+	r.mu.RLock()
+	batch := r.batch
+	r.mu.RUnlock()
+
+	msgs, err := r.source.unsent(batch, time.Now().Unix())
+	if err != nil {
+		return 0, err
+	}
+	var published int
+	blocked := make(map[string]bool)
+	for _, msg := range msgs {
+		if blocked[msg.Aggregate] {
+			continue
+		}
+		now := time.Now()
+		if msg.NextAt > now.Unix() {
+			blocked[msg.Aggregate] = true
+			continue
+		}
+		if err = ctx.Err(); err != nil {
+			return published, err
+		}
+		msg.Attempts++
+		if perr := r.publisher.Publish(ctx, msg); perr != nil {
+			blocked[msg.Aggregate] = true
+			msg.NextAt = now.Add(r.backoff(msg.Attempts)).Unix()
+			msg.LastError = perr.Error()
+			if len(msg.LastError) > 1024 {
+				msg.LastError = msg.LastError[:1024]
+			}
+			err = r.source.retry(msg)
+		} else {
+			msg.SentAt = now.Unix()
+			err = r.source.markSent(msg)
+			if err == nil {
+				published++
+			}
+		}
+		if err != nil {
+			return published, err
+		}
+	}
+	return published, nil
+}
+
+// Returns the delay of the retry after the attempts.
+func (r *Relay) backoff(attempts int) time.Duration {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	delay := r.minBackoff
+	for i := 1; i < attempts && delay < r.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > r.maxBackoff {
+		delay = r.maxBackoff
+	}
+	return delay
+}
@@ -0,0 +1,115 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type memSource []*Message
+
+func (s memSource) unsent(limit int, now int64) ([]*Message, error) {
+	waiting := make(map[string]bool)
+	for _, msg := range s {
+		if msg.SentAt == 0 && msg.NextAt > now {
+			waiting[msg.Aggregate] = true
+		}
+	}
+	var msgs []*Message
+	for _, msg := range s {
+		if msg.SentAt == 0 && !waiting[msg.Aggregate] && len(msgs) < limit {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs, nil
+}
+func (s memSource) markSent(*Message) error { return nil }
+func (s memSource) retry(*Message) error    { return nil }
+
+func TestRelayOrder(t *testing.T) {
+	source := memSource{
+		{Id: 1, Aggregate: "a"},
+		{Id: 2, Aggregate: "b"},
+		{Id: 3, Aggregate: "a"},
+		{Id: 4, Aggregate: "b"},
+	}
+	var (
+		published []int64
+		fail      = true
+	)
+	r := newRelay(source, PublisherFunc(func(_ context.Context, msg *Message) error {
+		if msg.Id == 1 && fail {
+			fail = false
+			return errors.New("broker is down")
+		}
+		published = append(published, msg.Id)
+		return nil
+	}))
+	r.SetBackoff(0, 0)
+
+	// The message 3 waits for the failed message 1.
+	if _, err := r.RelayOnce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(published) != 2 || published[0] != 2 || published[1] != 4 {
+		t.Fatalf("first round: %v", published)
+	}
+	if source[0].Attempts != 1 || source[0].LastError == "" {
+		t.Fatalf("failed attempt: %+v", source[0])
+	}
+
+	if _, err := r.RelayOnce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(published) != 4 || published[2] != 1 || published[3] != 3 {
+		t.Fatalf("second round: %v", published)
+	}
+}
+
+func TestRelayWaiting(t *testing.T) {
+	later := time.Now().Add(time.Hour).Unix()
+	source := memSource{
+		{Id: 1, Aggregate: "a", NextAt: later},
+		{Id: 2, Aggregate: "a"},
+		{Id: 3, Aggregate: "b"},
+	}
+	var published []int64
+	r := newRelay(source, PublisherFunc(func(_ context.Context, msg *Message) error {
+		msg.SentAt = 1
+		published = append(published, msg.Id)
+		return nil
+	}))
+	r.SetBatch(1)
+
+	// The aggregate waiting for a retry does not fill the batch.
+	n, err := r.RelayOnce(context.Background())
+	if err != nil || n != 1 || len(published) != 1 || published[0] != 3 {
+		t.Fatalf("relay: %d %v %v", n, published, err)
+	}
+	n, err = r.RelayOnce(context.Background())
+	if err != nil || n != 0 || len(published) != 1 {
+		t.Fatalf("waiting: %d %v %v", n, published, err)
+	}
+
+	// Run sleeps if nothing is published.
+	r.SetInterval(time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err = r.Run(ctx); err != context.DeadlineExceeded || len(published) != 1 {
+		t.Fatalf("run: %v %v", published, err)
+	}
+}
+
+func TestRelayBackoff(t *testing.T) {
+	r := newRelay(memSource{}, nil)
+	r.SetBackoff(time.Second, 5*time.Second)
+	for attempts, want := range []time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 9: 5 * time.Second} {
+		if want == 0 {
+			continue
+		}
+		if got := r.backoff(attempts); got != want {
+			t.Errorf("attempts %d: got %v, want %v", attempts, got, want)
+		}
+	}
+}
@@ -1,8 +1,6 @@
 package opay
 
 import (
-	"log"
-	"runtime"
 	"sync"
 	"time"
 )
@@ -12,15 +10,24 @@ type (
 	Queue interface {
 		GetCap() int
 		SetCap(int)
+		// Len returns the number of the queued orders.
+		Len() int
 		Push(Request) (respChan <-chan *Response)
-		Pull() Request
+		// Pull reads an order, ok is false when the queue is closed and drained.
+		Pull() (req Request, ok bool)
+		// Close stops accepting new orders, the queued ones can still be pulled.
+		Close()
 		GetOpay() *Opay
 	}
 	// OrderChan order chan
 	OrderChan struct {
-		c    chan Request
-		mu   sync.RWMutex
-		opay *Opay
+		c       chan Request
+		renew   chan struct{} //closed when c is replaced
+		closing chan struct{} //closed when the queue is closed
+		cmu     sync.Mutex    //guards c and renew, never held while blocking
+		mu      sync.RWMutex  //held by pushers, locked to wait for them
+		once    sync.Once
+		opay    *Opay
 	}
 )
 
@@ -33,35 +40,56 @@ func newOrderChan(queueCapacity int, opay *Opay) Queue {
 		queueCapacity = DEFAULT_QUEUE_CAP
 	}
 	return &OrderChan{
-		c:    make(chan Request, queueCapacity),
-		opay: opay,
+		c:       make(chan Request, queueCapacity),
+		renew:   make(chan struct{}),
+		closing: make(chan struct{}),
+		opay:    opay,
 	}
 }
 
 // GetCap returns queue capacity.
 func (oc *OrderChan) GetCap() int {
-	oc.mu.RLock()
-	defer oc.mu.RUnlock()
-	return cap(oc.c)
+	c, _ := oc.current()
+	return cap(c)
+}
+
+// Len returns the number of the queued orders.
+func (oc *OrderChan) Len() int {
+	c, _ := oc.current()
+	return len(c)
 }
 
 // SetCap sets the queue capacity.
+// The remaining orders are moved to the new queue,
+// whose capacity is extended if needed to hold them.
 func (oc *OrderChan) SetCap(queueCapacity int) {
 	if queueCapacity <= 0 {
 		queueCapacity = DEFAULT_QUEUE_CAP
 	}
-	close(oc.c)
-	if len(oc.c) > 0 {
-		log.Println("Waiting for the completion of the remaining order processing...")
-		for len(oc.c) > 0 {
-			runtime.Gosched()
-		}
-	}
+
+	// Waiting for the pushers.
 	oc.mu.Lock()
+	defer oc.mu.Unlock()
+
+	oc.cmu.Lock()
+	old := oc.c
+	if len(old) > queueCapacity {
+		oc.opay.logger.Printf("Extend the queue capacity to hold the remaining orders.")
+		queueCapacity = len(old)
+	}
 	oc.c = make(chan Request, queueCapacity)
-	oc.mu.Unlock()
+	close(oc.renew)
+	oc.renew = make(chan struct{})
+	for len(old) > 0 {
+		select {
+		case req := <-old:
+			oc.c <- req
+		default:
+		}
+	}
+	oc.cmu.Unlock()
 
-	log.Println("Successfully set the queue capacity.")
+	oc.opay.logger.Printf("Successfully set the queue capacity.")
 }
 
 // Push an order
@@ -76,6 +104,14 @@ func (oc *OrderChan) Push(req Request) (respChan <-chan *Response) {
 		return
 	}
 
+	select {
+	case <-oc.closing:
+		req.setError(ErrQueueClosed)
+		req.writeback()
+		return
+	default:
+	}
+
 	timeout, err := checkTimeout(req.Deadline)
 
 	if err != nil {
@@ -85,39 +121,63 @@ func (oc *OrderChan) Push(req Request) (respChan <-chan *Response) {
 		return
 	}
 
+	c, _ := oc.current()
+	var expire <-chan time.Time
 	if timeout > 0 {
 		// Not timed out
-		select {
-		case oc.c <- req:
-		case <-time.After(timeout):
-			err = ErrTimeout
-			req.setError(err)
-			req.writeback()
-		}
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expire = timer.C
+	}
 
-	} else {
-		// No timeout limit
-		oc.c <- req
+	select {
+	case c <- req:
+	case <-oc.closing:
+		req.setError(ErrQueueClosed)
+		req.writeback()
+	case <-expire:
+		req.setError(ErrTimeout)
+		req.writeback()
+	case <-req.Context().Done():
+		req.setError(req.Context().Err())
+		req.writeback()
 	}
 
 	return
 }
 
 // Read an order.
-// Wait indefinitely until a valid order is taken.
+// Wait until a valid order is taken or the queue is closed and drained.
 // Automatically processes overtime orders.
-func (oc *OrderChan) Pull() Request {
+func (oc *OrderChan) Pull() (Request, bool) {
 	var (
-		req Request
-		c   chan Request
+		req   Request
+		drain bool
 	)
 
 	for {
-		oc.mu.RLock()
-		c = oc.c
-		oc.mu.RUnlock()
+		c, renew := oc.current()
+
+		if drain {
+			select {
+			case req = <-c:
+			default:
+				return Request{}, false
+			}
+		} else {
+			select {
+			case req = <-c:
+			case <-renew:
+				continue
+			case <-oc.closing:
+				// Waiting for the pushers who have seen the queue open.
+				oc.mu.Lock()
+				drain = true
+				oc.mu.Unlock()
+				continue
+			}
+		}
 
-		req = <-c
 		if req.isNil() {
 			continue
 		}
@@ -128,15 +188,33 @@ func (oc *OrderChan) Pull() Request {
 			req.writeback()
 			continue
 		}
+
+		// If the caller has gone, cancel the order.
+		if err := req.Context().Err(); err != nil {
+			req.setError(err)
+			req.writeback()
+			continue
+		}
 		break
 	}
 
-	return req
+	return req, true
+}
+
+// Close stops accepting new orders.
+func (oc *OrderChan) Close() {
+	oc.once.Do(func() {
+		close(oc.closing)
+	})
 }
 
 // GetOpay returns Opay
 func (oc *OrderChan) GetOpay() *Opay {
-	oc.mu.RLock()
-	defer oc.mu.RUnlock()
 	return oc.opay
 }
+
+func (oc *OrderChan) current() (chan Request, chan struct{}) {
+	oc.cmu.Lock()
+	defer oc.cmu.Unlock()
+	return oc.c, oc.renew
+}
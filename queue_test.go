@@ -0,0 +1,178 @@
+package opay
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type testOrder struct {
+	meta   *Meta
+	pre    int64
+	target int64
+	uid    string
+	aid    string
+	amount Amount
+}
+
+func (o *testOrder) GetMeta() *Meta              { return o.meta }
+func (o *testOrder) PreStatus() int64            { return o.pre }
+func (o *testOrder) TargetStatus() int64         { return o.target }
+func (o *testOrder) GetUid() string              { return o.uid }
+func (o *testOrder) GetAid() string              { return o.aid }
+func (o *testOrder) GetAmount() Amount           { return o.amount }
+func (o *testOrder) Pend(*sqlx.Tx, KV) error     { return nil }
+func (o *testOrder) Do(*sqlx.Tx, KV) error       { return nil }
+func (o *testOrder) Succeed(*sqlx.Tx, KV) error  { return nil }
+func (o *testOrder) Cancel(*sqlx.Tx, KV) error   { return nil }
+func (o *testOrder) Fail(*sqlx.Tx, KV) error     { return nil }
+func (o *testOrder) SyncDeal(*sqlx.Tx, KV) error { return nil }
+
+func newTestOpay(t *testing.T, queueCapacity int) (*Opay, *Meta) {
+	o := NewOpay(nil, queueCapacity, 2)
+	meta, err := o.RegMeta("test", HandlerFunc(func(*Context) error { return nil }), []Status{
+		{Code: 1, Note: "pend", Step: PEND},
+		{Code: 2, Note: "succeed", Step: SUCCEED},
+		{Code: 3, Note: "cancel", Step: CANCEL},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o, meta
+}
+
+// Returns an in-memory sqlite database, closed after the test.
+func newTestDB(t *testing.T) *sqlx.DB {
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection opens a new in-memory database.
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// Returns the SettleFuncMap with the no-op SettleFunc of the asset "1".
+func newTestSettles() *SettleFuncMap {
+	m := NewSettleFuncMap()
+	m.RegSettleFunc("1", func(string, Amount, *sqlx.Tx) error { return nil })
+	return m
+}
+
+// Starts o, and shuts it down after the test.
+func startTestOpay(t *testing.T, o *Opay) {
+	if err := o.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { o.Shutdown(context.Background()) })
+}
+
+func newTestRequest(meta *Meta, uid string, amount float64) Request {
+	return Request{
+		Initiator: &testOrder{
+			meta:   meta,
+			pre:    meta.UnsetCode(),
+			target: 1,
+			uid:    uid,
+			aid:    "1",
+			amount: MustAmountFromFloat(amount, 2),
+		},
+	}
+}
+
+func TestWait(t *testing.T) {
+	o := New(newTestDB(t))
+	if err := o.Wait(); err != ErrNotStarted {
+		t.Fatalf("not started: %v", err)
+	}
+	if err := o.Shutdown(context.Background()); err != ErrNotStarted {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if err := o.Wait(); err != ErrNotStarted {
+		t.Fatalf("shut down before starting: %v", err)
+	}
+
+	o = New(newTestDB(t))
+	if err := o.Start(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- o.Wait() }()
+	if err := o.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("started: %v", err)
+	}
+}
+
+func TestOrderChanClose(t *testing.T) {
+	o, meta := newTestOpay(t, 4)
+	q := o.queue
+
+	q.Push(newTestRequest(meta, "a", 1))
+	q.Push(newTestRequest(meta, "b", 2))
+	q.Close()
+
+	resp := <-q.Push(newTestRequest(meta, "c", 3))
+	if resp.Err != ErrQueueClosed {
+		t.Fatalf("push after close: %v", resp.Err)
+	}
+
+	for _, uid := range []string{"a", "b"} {
+		req, ok := q.Pull()
+		if !ok {
+			t.Fatalf("pull %s: queue is drained too early", uid)
+		}
+		if req.Initiator.GetUid() != uid {
+			t.Fatalf("pull: got %s, want %s", req.Initiator.GetUid(), uid)
+		}
+	}
+	if _, ok := q.Pull(); ok {
+		t.Fatal("pull from the drained queue")
+	}
+}
+
+func TestOrderChanSetCap(t *testing.T) {
+	o, meta := newTestOpay(t, 4)
+	q := o.queue
+
+	q.Push(newTestRequest(meta, "a", 1))
+	q.Push(newTestRequest(meta, "b", 2))
+	q.SetCap(1)
+	if q.GetCap() != 2 {
+		t.Fatalf("cap: got %d, want 2", q.GetCap())
+	}
+	req, ok := q.Pull()
+	if !ok || req.Initiator.GetUid() != "a" {
+		t.Fatal("lost order after SetCap")
+	}
+}
+
+func TestOrderChanContext(t *testing.T) {
+	o, meta := newTestOpay(t, 1)
+	q := o.queue
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := newTestRequest(meta, "a", 1)
+	q.Push(req.WithContext(ctx))
+
+	// The queue is full, waiting until cancel.
+	done := make(chan *Response, 1)
+	req = newTestRequest(meta, "b", 2)
+	go func() { done <- <-q.Push(req.WithContext(ctx)) }()
+	cancel()
+	if resp := <-done; resp.Err != context.Canceled {
+		t.Fatalf("push: got %v, want %v", resp.Err, context.Canceled)
+	}
+
+	// The canceled order is dropped when pulled.
+	go q.Push(newTestRequest(meta, "c", 3))
+	req, ok := q.Pull()
+	if !ok || req.Initiator.GetUid() != "c" {
+		t.Fatal("pull the canceled order")
+	}
+}
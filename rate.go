@@ -0,0 +1,276 @@
+package opay
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type (
+	// RateProvider provides the current exchange rates.
+	RateProvider interface {
+		// Rate returns the exchange rate from an asset to another, i.e. 1 from = rate to.
+		Rate(from, to string) (Amount, error)
+	}
+
+	// Quote is an exchange rate locked for a while for a user,
+	// which the client obtains before exchanging, and can be used only once.
+	Quote struct {
+		Id         string `json:"id" db:"id"`
+		Uid        string `json:"uid" db:"uid"` //the requester
+		From       string `json:"from" db:"from_aid"`
+		To         string `json:"to" db:"to_aid"`
+		Rate       Amount `json:"rate" db:"rate"` //1 From = Rate To
+		CreatedAt  int64  `json:"created_at" db:"created_at"`
+		ExpiresAt  int64  `json:"expires_at" db:"expires_at"`
+		ConsumedAt int64  `json:"consumed_at" db:"consumed_at"` //0 if not used
+	}
+
+	// RateStore stores the quotes, which is also the history of the rates for audit.
+	RateStore interface {
+		// SaveQuote saves the quote.
+		SaveQuote(quote *Quote) error
+		// GetQuote returns the quote, or nil if not exist.
+		GetQuote(id string) (*Quote, error)
+		// ConsumeQuote marks the quote as used in the transaction,
+		// it returns ErrQuoteConsumed if the quote has been used.
+		ConsumeQuote(tx *sqlx.Tx, id string, now int64) error
+	}
+
+	// QuoteBook issues the quotes, and checks the exchanges against them.
+	QuoteBook struct {
+		provider RateProvider
+		store    RateStore
+		ttl      time.Duration
+		slippage Amount //the max relative deviation from the quote
+		mu       sync.RWMutex
+	}
+
+	// DBRateStore is a RateStore on a table.
+	DBRateStore struct {
+		db      *sqlx.DB
+		dialect Dialect
+		table   string
+	}
+)
+
+const (
+	QUOTE_ID_KEY      = "opay_quote_id"  // QUOTE_ID_KEY is the key of the quote id in Request.Addition
+	DEFAULT_QUOTE_TTL = 30 * time.Second // DEFAULT_QUOTE_TTL is the default time to live of the quotes
+)
+
+var _ RateStore = (*DBRateStore)(nil)
+
+// NewQuoteBook creates a QuoteBook, the quotes expire after ttl, or DEFAULT_QUOTE_TTL if ttl is 0.
+func NewQuoteBook(provider RateProvider, store RateStore, ttl time.Duration) *QuoteBook {
+	if ttl <= 0 {
+		ttl = DEFAULT_QUOTE_TTL
+	}
+	return &QuoteBook{
+		provider: provider,
+		store:    store,
+		ttl:      ttl,
+	}
+}
+
+// SetSlippage sets the max relative shortfall of the received amount from the quote, e.g. 0.001 for 0.1%.
+func (b *QuoteBook) SetSlippage(slippage Amount) {
+	b.mu.Lock()
+	b.slippage = slippage.Abs()
+	b.mu.Unlock()
+}
+
+// Quote locks the current rate from an asset to another for the user, and saves it.
+func (b *QuoteBook) Quote(uid, from, to string) (*Quote, error) {
+	rate, err := b.provider.Rate(from, to)
+	if err != nil {
+		return nil, err
+	}
+	if rate.Sign() <= 0 {
+		return nil, ErrIncorrectAmount
+	}
+	id := make([]byte, 16)
+	if _, err = rand.Read(id); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	quote := &Quote{
+		Id:        hex.EncodeToString(id),
+		Uid:       uid,
+		From:      from,
+		To:        to,
+		Rate:      rate,
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.Add(b.ttl).Unix(),
+	}
+	if err = b.store.SaveQuote(quote); err != nil {
+		return nil, err
+	}
+	return quote, nil
+}
+
+// Check checks that the exchange of the user paying the amount of from and receiving the amount of to
+// matches the unused quote within the slippage, call Consume to use the quote.
+func (b *QuoteBook) Check(id, uid string, from string, pay Amount, to string, receive Amount) error {
+	quote, err := b.store.GetQuote(id)
+	if err != nil {
+		return err
+	}
+	if quote == nil {
+		return ErrQuoteNotFound
+	}
+	if quote.ConsumedAt != 0 {
+		return ErrQuoteConsumed
+	}
+	if time.Now().Unix() > quote.ExpiresAt {
+		return ErrQuoteExpired
+	}
+	if quote.Uid != uid || quote.From != from || quote.To != to {
+		return ErrQuoteMismatch
+	}
+	b.mu.RLock()
+	slippage := b.slippage
+	b.mu.RUnlock()
+	if !withinSlippage(pay.Abs(), receive.Abs(), quote.Rate, slippage) {
+		return ErrQuoteMismatch
+	}
+	return nil
+}
+
+// Consume marks the quote as used in the transaction, so that it can not be used again.
+func (b *QuoteBook) Consume(tx *sqlx.Tx, id string) error {
+	return b.store.ConsumeQuote(tx, id, time.Now().Unix())
+}
+
+// Reports whether pay*rate*(1-slippage) <= receive <= pay*rate,
+// only the shortfall of the received amount is tolerated.
+func withinSlippage(pay, receive, rate, slippage Amount) bool {
+	expected := new(big.Rat).Mul(pay.rat(), rate.rat())
+	tolerance := new(big.Rat).Mul(expected, slippage.rat())
+	shortfall := new(big.Rat).Sub(expected, receive.rat())
+	return shortfall.Sign() >= 0 && shortfall.Cmp(tolerance) <= 0
+}
+
+// NewDBRateStore creates a RateStore on the table.
+func NewDBRateStore(db *sqlx.DB, table string) *DBRateStore {
+	return &DBRateStore{
+		db:      db,
+		dialect: DialectOf(db.DriverName()),
+		table:   table,
+	}
+}
+
+// CreateTable creates the table if not exists.
+func (s *DBRateStore) CreateTable() error {
+	return s.dialect.Exec(s.db, s.dialect.CreateTable(s.table, []string{
+		"id CHAR(32) NOT NULL PRIMARY KEY",
+		"uid VARCHAR(64) NOT NULL",
+		"from_aid VARCHAR(16) NOT NULL",
+		"to_aid VARCHAR(16) NOT NULL",
+		"rate " + s.dialect.Decimal() + " NOT NULL",
+		"created_at BIGINT NOT NULL",
+		"expires_at BIGINT NOT NULL",
+		"consumed_at BIGINT NOT NULL DEFAULT 0",
+	}, "from_aid, to_aid, created_at"))
+}
+
+// SaveQuote implements RateStore.
+func (s *DBRateStore) SaveQuote(quote *Quote) error {
+	_, err := s.db.Exec(s.db.Rebind(
+		"INSERT INTO "+s.table+" (id, uid, from_aid, to_aid, rate, created_at, expires_at, consumed_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"),
+		quote.Id, quote.Uid, quote.From, quote.To, quote.Rate, quote.CreatedAt, quote.ExpiresAt, quote.ConsumedAt,
+	)
+	return err
+}
+
+// GetQuote implements RateStore.
+func (s *DBRateStore) GetQuote(id string) (*Quote, error) {
+	var quote Quote
+	err := s.db.Get(&quote, s.db.Rebind(
+		"SELECT id, uid, from_aid, to_aid, rate, created_at, expires_at, consumed_at FROM "+s.table+" WHERE id = ?"),
+		id,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &quote, nil
+}
+
+// ConsumeQuote implements RateStore.
+func (s *DBRateStore) ConsumeQuote(tx *sqlx.Tx, id string, now int64) error {
+	result, err := tx.Exec(tx.Rebind(
+		"UPDATE "+s.table+" SET consumed_at = ? WHERE id = ? AND consumed_at = 0"),
+		now, id,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err == nil && n == 0 {
+		err = ErrQuoteConsumed
+	}
+	return err
+}
+
+// History returns the quotes from an asset to another in the time range, the latest first.
+func (s *DBRateStore) History(from, to string, since, until time.Time) ([]*Quote, error) {
+	var quotes []*Quote
+	err := s.db.Select(&quotes, s.db.Rebind(
+		"SELECT id, uid, from_aid, to_aid, rate, created_at, expires_at, consumed_at FROM "+s.table+
+			" WHERE from_aid = ? AND to_aid = ? AND created_at >= ? AND created_at < ? ORDER BY created_at DESC"),
+		from, to, since.Unix(), until.Unix(),
+	)
+	return quotes, err
+}
+
+// SetQuoteBook sets the QuoteBook checking the exchanges,
+// it must be called before starting.
+func (opay *Opay) SetQuoteBook(book *QuoteBook) error {
+	opay.stateMu.Lock()
+	defer opay.stateMu.Unlock()
+	if opay.started {
+		return ErrStarted
+	}
+	opay.quotes = book
+	return nil
+}
+
+// QuoteBook returns the QuoteBook, or nil if not set.
+func (opay *Opay) QuoteBook() *QuoteBook {
+	return opay.quotes
+}
+
+// CheckQuote checks the exchange of the initiator paying and the stakeholder receiving
+// against the initiator's quote whose id is Addition[QUOTE_ID_KEY], when the order is created,
+// then marks the quote as used in the transaction.
+// It does nothing if no QuoteBook is set.
+func (ctx *Context) CheckQuote() error {
+	book := ctx.opay.quotes
+	if book == nil {
+		return nil
+	}
+	initiator, stakeholder := ctx.Request.Initiator, ctx.Request.Stakeholder
+	if stakeholder == nil {
+		return ErrStakeholderNotExist
+	}
+	if pre, _ := initiator.GetMeta().Status(initiator.PreStatus()); pre.Step != UNSET {
+		return nil
+	}
+	id, _ := ctx.Get(QUOTE_ID_KEY).(string)
+	if len(id) == 0 {
+		return ErrQuoteNotFound
+	}
+	err := book.Check(id, initiator.GetUid(), initiator.GetAid(), initiator.GetAmount(), stakeholder.GetAid(), stakeholder.GetAmount())
+	if err != nil {
+		return err
+	}
+	return book.Consume(ctx.Request.Tx, id)
+}
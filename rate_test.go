@@ -0,0 +1,94 @@
+package opay
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type testRates map[string]*Quote
+
+func (r testRates) Rate(from, to string) (Amount, error) { return MustParseAmount("6.8321"), nil }
+func (r testRates) SaveQuote(q *Quote) error             { r[q.Id] = q; return nil }
+func (r testRates) GetQuote(id string) (*Quote, error)   { return r[id], nil }
+func (r testRates) ConsumeQuote(_ *sqlx.Tx, id string, now int64) error {
+	if r[id].ConsumedAt != 0 {
+		return ErrQuoteConsumed
+	}
+	r[id].ConsumedAt = now
+	return nil
+}
+
+func TestQuoteBook(t *testing.T) {
+	rates := testRates{}
+	book := NewQuoteBook(rates, rates, time.Minute)
+	book.SetSlippage(MustParseAmount("0.001"))
+
+	quote, err := book.Quote("u1", "usd", "cny")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 100 usd = 683.21 cny, -0.68321
+	for _, c := range []struct {
+		uid, to, receive string
+		err              error
+	}{
+		{"u1", "cny", "683.21", nil},
+		{"u1", "cny", "682.53", nil},
+		{"u1", "cny", "682.52", ErrQuoteMismatch},
+		{"u1", "cny", "683.22", ErrQuoteMismatch},
+		{"u1", "eur", "683.21", ErrQuoteMismatch},
+		{"u2", "cny", "683.21", ErrQuoteMismatch},
+	} {
+		err = book.Check(quote.Id, c.uid, "usd", MustParseAmount("-100"), c.to, MustParseAmount(c.receive))
+		if err != c.err {
+			t.Errorf("%s %s %s: got %v, want %v", c.uid, c.to, c.receive, err, c.err)
+		}
+	}
+
+	if err = book.Check("none", "u1", "usd", MustParseAmount("-100"), "cny", MustParseAmount("683.21")); err != ErrQuoteNotFound {
+		t.Fatalf("not found: %v", err)
+	}
+	quote.ExpiresAt = time.Now().Add(-time.Second).Unix()
+	if err = book.Check(quote.Id, "u1", "usd", MustParseAmount("-100"), "cny", MustParseAmount("683.21")); err != ErrQuoteExpired {
+		t.Fatalf("expired: %v", err)
+	}
+	if err = book.Consume(nil, quote.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err = book.Check(quote.Id, "u1", "usd", MustParseAmount("-100"), "cny", MustParseAmount("683.21")); err != ErrQuoteConsumed {
+		t.Fatalf("consumed: %v", err)
+	}
+}
+
+func TestDBRateStore(t *testing.T) {
+	db := newTestDB(t)
+	store := NewDBRateStore(db, "opay_quote")
+	if err := store.CreateTable(); err != nil {
+		t.Fatal(err)
+	}
+	want := &Quote{Id: "q1", Uid: "u1", From: "usd", To: "jpy", Rate: MustParseAmount("151.25"), CreatedAt: 1, ExpiresAt: 2}
+	if err := store.SaveQuote(want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.GetQuote("q1")
+	if err != nil || got == nil || *got != *want {
+		t.Fatalf("get: %+v %v", got, err)
+	}
+
+	for i, want := range []error{nil, ErrQuoteConsumed} {
+		tx, err := db.Beginx()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = store.ConsumeQuote(tx, "q1", 3)
+		tx.Commit()
+		if err != want {
+			t.Fatalf("consume %d: %v", i, err)
+		}
+	}
+	if got, err = store.GetQuote("q1"); err != nil || got.ConsumedAt != 3 {
+		t.Fatalf("consumed: %+v %v", got, err)
+	}
+}
@@ -0,0 +1,117 @@
+package opay
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+type (
+	// StaleOrderFinder queries the order store for the Reaper.
+	StaleOrderFinder interface {
+		// FindStale returns at most limit requests of the orders of the meta,
+		// which stay in a status of the step since before,
+		// with the orders retargeted to the target status.
+		FindStale(meta *Meta, step Step, before time.Time, target Status, limit int) ([]Request, error)
+	}
+
+	// Reaper cancels the orders pending longer than the TTL of their Meta,
+	// and fails the ones doing longer than it, through the normal Opay.Do path,
+	// so that the handlers roll back the balance.
+	Reaper struct {
+		opay     *Opay
+		finder   StaleOrderFinder
+		interval time.Duration
+		limit    int
+	}
+)
+
+const (
+	DEFAULT_REAP_LIMIT    = 100         // DEFAULT_REAP_LIMIT is the default number of the orders reaped per meta and step in a round
+	DEFAULT_REAP_INTERVAL = time.Minute // DEFAULT_REAP_INTERVAL is the default interval of the rounds
+)
+
+// NewReaper creates a reaper of opay, which runs every interval,
+// DEFAULT_REAP_INTERVAL is used if interval <= 0.
+func NewReaper(opay *Opay, finder StaleOrderFinder, interval time.Duration) *Reaper {
+	if interval <= 0 {
+		interval = DEFAULT_REAP_INTERVAL
+	}
+	return &Reaper{
+		opay:     opay,
+		finder:   finder,
+		interval: interval,
+		limit:    DEFAULT_REAP_LIMIT,
+	}
+}
+
+// SetLimit sets the number of the orders reaped per meta and step in a round.
+func (r *Reaper) SetLimit(limit int) {
+	if limit <= 0 {
+		limit = DEFAULT_REAP_LIMIT
+	}
+	r.limit = limit
+}
+
+// Run reaps every interval until ctx is done.
+func (r *Reaper) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		if _, err := r.Reap(ctx); err != nil && ctx.Err() == nil {
+			r.opay.logger.Printf("opay: reaper: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reap cancels or fails the expired orders once, returns the number of the reaped ones.
+// The failures of a single order are logged, and do not stop the others.
+func (r *Reaper) Reap(ctx context.Context) (reaped int, err error) {
+	for _, meta := range r.opay.Metas() {
+		ttl := meta.TTL()
+		if ttl <= 0 {
+			continue
+		}
+		before := r.opay.Now().Add(-ttl)
+		for _, step := range []Step{PEND, DO} {
+			target, ok := r.target(meta, step)
+			if !ok {
+				continue
+			}
+			reqs, err := r.finder.FindStale(meta, step, before, target, r.limit)
+			if err != nil {
+				return reaped, err
+			}
+			for _, req := range reqs {
+				if err = ctx.Err(); err != nil {
+					return reaped, err
+				}
+				resp := r.opay.DoContext(ctx, req)
+				switch {
+				case resp.Err == nil:
+					reaped++
+				case errors.Is(resp.Err, ErrReprocess), errors.Is(resp.Err, ErrInvalidStep), errors.Is(resp.Err, ErrCancelStep):
+					// Has been processed by others.
+				default:
+					r.opay.logger.Printf("opay: reaper: %s order %s: %v", meta.OrderType(), OrderId(req.Initiator), resp.Err)
+				}
+			}
+		}
+	}
+	return
+}
+
+// Returns the target status of the expired orders in the step.
+func (r *Reaper) target(meta *Meta, step Step) (Status, bool) {
+	if step == PEND {
+		if status, ok := meta.StepStatus(CANCEL); ok {
+			return status, ok
+		}
+	}
+	return meta.StepStatus(FAIL)
+}
@@ -0,0 +1,70 @@
+package opay
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type testFinder struct {
+	orders []*testOrder
+	before time.Time
+	steps  []Step
+}
+
+func (f *testFinder) FindStale(meta *Meta, step Step, before time.Time, target Status, limit int) ([]Request, error) {
+	f.before = before
+	f.steps = append(f.steps, step)
+	if step != PEND {
+		return nil, nil
+	}
+	var reqs []Request
+	for _, o := range f.orders {
+		o.pre, o.target = o.target, target.Code
+		reqs = append(reqs, Request{Initiator: o})
+	}
+	return reqs, nil
+}
+
+func TestReap(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	o := New(newTestDB(t), WithQueueCapacity(4), WithClock(func() time.Time { return now }), WithSettleFuncMap(newTestSettles()))
+	var canceled []string
+	meta, err := o.RegMeta("test", HandlerFunc(func(ctx *Context) error {
+		if ctx.Initiator.GetUid() == "other" {
+			// Canceled by the callback concurrently.
+			return ErrReprocess
+		}
+		canceled = append(canceled, ctx.Initiator.GetUid())
+		return ctx.Cancel()
+	}), []Status{
+		{Code: 1, Note: "pend", Step: PEND},
+		{Code: 2, Note: "succeed", Step: SUCCEED},
+		{Code: 3, Note: "cancel", Step: CANCEL},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta.SetTTL(time.Hour)
+	startTestOpay(t, o)
+
+	finder := &testFinder{}
+	for _, uid := range []string{"a", "other"} {
+		finder.orders = append(finder.orders, &testOrder{meta: meta, target: 1, uid: uid, aid: "1", amount: MustParseAmount("1")})
+	}
+	r := NewReaper(o, finder, 0)
+	if r.interval != DEFAULT_REAP_INTERVAL {
+		t.Fatalf("interval: %s", r.interval)
+	}
+	reaped, err := r.Reap(context.Background())
+	if err != nil || reaped != 1 {
+		t.Fatalf("reap: %d %v", reaped, err)
+	}
+	if len(canceled) != 1 || canceled[0] != "a" || finder.orders[0].target != 3 {
+		t.Fatalf("canceled: %v", canceled)
+	}
+	// DO is skipped, since there is no FAIL status.
+	if !finder.before.Equal(now.Add(-time.Hour)) || len(finder.steps) != 1 {
+		t.Fatalf("find: %s %v", finder.before, finder.steps)
+	}
+}
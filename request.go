@@ -1,6 +1,7 @@
 package opay
 
 import (
+	"context"
 	"sync"
 	"time"
 
@@ -8,15 +9,19 @@ import (
 )
 
 type Request struct {
-	Deadline    time.Time              //handle timeouts, if do not fill, no limit
-	Addition    map[string]interface{} //additional params
-	Initiator   IOrder                 //master order
-	Stakeholder IOrder                 //the optional, slave order
-	response    *Response
-	*sqlx.Tx    //the optional, database transaction
-	operator    string
-	step        Step
-	lock        sync.RWMutex
+	Deadline       time.Time              //handle timeouts, if do not fill, no limit
+	Addition       map[string]interface{} //additional params
+	Initiator      IOrder                 //master order
+	Stakeholder    IOrder                 //the optional, slave order
+	Parties        []IOrder               //the optional, orders of the other parties, such as the split receivers
+	IdempotencyKey string                 //the optional, the repeated request returns the original response
+	response       *Response
+	*sqlx.Tx       //the optional, database transaction, the writes of the failed request are rolled back to a savepoint, the caller must commit it and then publish Response.Events by Opay.Publish
+	ctx            context.Context
+	ack            func(*sqlx.Tx) error //the optional, called in the transaction before committing
+	operator       string
+	step           Step
+	lock           sync.RWMutex
 }
 
 // 获取指定的订单处理操作符
@@ -26,6 +31,46 @@ func (req *Request) Operator() string {
 	return req.operator
 }
 
+// Context returns the request's context, which is Background if do not set.
+func (req *Request) Context() context.Context {
+	req.lock.RLock()
+	defer req.lock.RUnlock()
+	if req.ctx == nil {
+		return context.Background()
+	}
+	return req.ctx
+}
+
+// WithContext returns a copy of the request with its context changed to ctx.
+func (req *Request) WithContext(ctx context.Context) Request {
+	if ctx == nil {
+		panic("opay: nil context")
+	}
+	r := req.clone()
+	r.ctx = ctx
+	return r
+}
+
+// Returns a copy of the request.
+func (req *Request) clone() Request {
+	req.lock.RLock()
+	defer req.lock.RUnlock()
+	return Request{
+		Deadline:       req.Deadline,
+		Addition:       req.Addition,
+		Initiator:      req.Initiator,
+		Stakeholder:    req.Stakeholder,
+		Parties:        req.Parties,
+		IdempotencyKey: req.IdempotencyKey,
+		response:       req.response,
+		Tx:             req.Tx,
+		ctx:            req.ctx,
+		ack:            req.ack,
+		operator:       req.operator,
+		step:           req.step,
+	}
+}
+
 // 获取订单处理的行为目标
 func (req *Request) Step() Step {
 	req.lock.RLock()
@@ -38,12 +83,7 @@ func (req *Request) prepare(opay *Opay) (respChan <-chan *Response, err error) {
 	req.lock.Lock()
 	defer req.lock.Unlock()
 
-	c := make(chan *Response, 1)
-	respChan = (<-chan *Response)(c)
-
-	req.response = &Response{
-		respChan: (chan<- *Response)(c),
-	}
+	respChan = req.newResponse()
 
 	// The main order can not be empty.
 	if req.Initiator == nil {
@@ -53,6 +93,12 @@ func (req *Request) prepare(opay *Opay) (respChan <-chan *Response, err error) {
 
 	meta := req.Initiator.GetMeta()
 
+	// 检查订单类型是否已注册
+	if meta == nil || !opay.registered(meta) {
+		err = ErrUnknownMeta
+		return
+	}
+
 	// 检查订单状态是否已注册
 	preStatus, ok := meta.Status(req.Initiator.PreStatus())
 	if !ok {
@@ -96,27 +142,43 @@ func (req *Request) prepare(opay *Opay) (respChan <-chan *Response, err error) {
 		return
 	}
 
-	// 主订单操作金额不能为0
-	if opay.Equal(req.Initiator.GetAmount(), 0) {
+	// 检查状态变更是否在声明的状态图中
+	if err = meta.checkTransition(preStatus.Code, targetStatus.Code); err != nil {
+		return
+	}
+
+	// 主订单操作金额不能为0，且精度不能超过设定的小数位数
+	if !opay.validAmount(req.Initiator.GetAmount()) {
 		err = ErrIncorrectAmount
 		return
 	}
 
-	// 检查从属订单
+	// 检查从属订单及参与方订单
+	// 复制而非引用req.Parties，避免后续append写入调用方的底层数组
+	slaves := make([]IOrder, 0, len(req.Parties)+2)
 	if req.Stakeholder != nil {
+		slaves = append(slaves, req.Stakeholder)
+	}
+	slaves = append(slaves, req.Parties...)
+	for _, slave := range slaves {
+		if slave == nil {
+			err = ErrPartyNil
+			return
+		}
+
 		// 检查主从订单类型是否一致
-		if req.Stakeholder.GetMeta() != meta {
+		if slave.GetMeta() != meta {
 			err = ErrDifferentType
 			return
 		}
 
 		// 检查订单状态是否已注册
-		preStatus2, ok := meta.Status(req.Stakeholder.PreStatus())
+		preStatus2, ok := meta.Status(slave.PreStatus())
 		if !ok {
 			err = ErrInvalidStatus
 			return
 		}
-		targetStatus2, ok := meta.Status(req.Stakeholder.TargetStatus())
+		targetStatus2, ok := meta.Status(slave.TargetStatus())
 		if !ok {
 			err = ErrInvalidStatus
 			return
@@ -128,14 +190,29 @@ func (req *Request) prepare(opay *Opay) (respChan <-chan *Response, err error) {
 			err = ErrDifferentStep
 			return
 		}
+		if err = meta.checkTransition(preStatus2.Code, targetStatus2.Code); err != nil {
+			return
+		}
 
-		// 从属订单操作金额不能为0
-		if opay.Equal(req.Stakeholder.GetAmount(), 0) {
+		// 从属订单操作金额不能为0，且精度不能超过设定的小数位数
+		if !opay.validAmount(slave.GetAmount()) {
 			err = ErrIncorrectAmount
 			return
 		}
 	}
 
+	// 检查各资产的金额之和是否为0，
+	// 设置了手续费引擎时，计入手续费后在处理订单时检查
+	if meta.ZeroSum() && opay.fees == nil {
+		if ok, err = zeroSum(append(slaves, req.Initiator), nil); err != nil {
+			return
+		}
+		if !ok {
+			err = ErrNotZeroSum
+			return
+		}
+	}
+
 	if req.Addition == nil {
 		req.Addition = make(map[string]interface{})
 	}
@@ -144,6 +221,71 @@ func (req *Request) prepare(opay *Opay) (respChan <-chan *Response, err error) {
 	return
 }
 
+// Creates a new response, the caller must hold the lock.
+func (req *Request) newResponse() <-chan *Response {
+	c := make(chan *Response, 1)
+	req.response = &Response{
+		respChan: (chan<- *Response)(c),
+	}
+	return (<-chan *Response)(c)
+}
+
+// Replaces the response with a new one.
+func (req *Request) renewResponse() <-chan *Response {
+	req.lock.Lock()
+	defer req.lock.Unlock()
+	return req.newResponse()
+}
+
+// Returns all the orders of the request, the initiator first.
+func (req *Request) orders() []IOrder {
+	orders := make([]IOrder, 0, len(req.Parties)+2)
+	orders = append(orders, req.Initiator)
+	if req.Stakeholder != nil {
+		orders = append(orders, req.Stakeholder)
+	}
+	return append(orders, req.Parties...)
+}
+
+// Returns the Uid-Aid accounts touched by the request, without repetition.
+func (req *Request) accounts() []string {
+	var (
+		accounts []string
+		seen     = make(map[string]bool)
+	)
+	for _, order := range req.orders() {
+		account := order.GetAid() + "\x00" + order.GetUid()
+		if !seen[account] {
+			seen[account] = true
+			accounts = append(accounts, account)
+		}
+	}
+	return accounts
+}
+
+// Reports whether the sum of the amounts of each asset,
+// including the fees credited to the fee accounts, is 0.
+// It returns ErrAmountOverflow if a sum overflows, since it runs in the caller's goroutine.
+func zeroSum(orders []IOrder, fees map[string]Amount) (bool, error) {
+	sums := make(map[string]Amount)
+	for aid, fee := range fees {
+		sums[aid] = fee
+	}
+	for _, order := range orders {
+		sum, err := sums[order.GetAid()].add(order.GetAmount())
+		if err != nil {
+			return false, err
+		}
+		sums[order.GetAid()] = sum
+	}
+	for _, sum := range sums {
+		if !sum.IsZero() {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
 func (req *Request) get(k string) interface{} {
 	req.lock.RLock()
 	defer req.lock.RUnlock()
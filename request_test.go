@@ -0,0 +1,53 @@
+package opay
+
+import (
+	"testing"
+)
+
+func TestRequestParties(t *testing.T) {
+	o, meta := newTestOpay(t, 1)
+	meta.SetZeroSum(true)
+
+	party := func(uid string, amount string) IOrder {
+		return &testOrder{meta: meta, pre: meta.UnsetCode(), target: 1, uid: uid, aid: "1", amount: MustParseAmount(amount)}
+	}
+	req := Request{
+		Initiator:   party("buyer", "-10"),
+		Stakeholder: party("seller", "8"),
+		Parties:     []IOrder{party("platform", "1.5"), party("tax", "0.4")},
+	}
+	if _, err := req.prepare(o); err != ErrNotZeroSum {
+		t.Fatalf("unbalanced: %v", err)
+	}
+
+	req.Parties = append(req.Parties, party("seller", "0.1"))
+	if _, err := req.prepare(o); err != nil {
+		t.Fatal(err)
+	}
+	if accounts := req.accounts(); len(accounts) != 4 {
+		t.Fatalf("accounts: %q", accounts)
+	}
+
+	// The overflowing sums are rejected instead of panicking in the caller's goroutine.
+	huge := NewAmount(1<<62, 0).String()
+	overflow := Request{Initiator: party("buyer", "-1"), Stakeholder: party("seller", huge), Parties: []IOrder{party("platform", huge)}}
+	if _, err := overflow.prepare(o); err != ErrAmountOverflow {
+		t.Fatalf("overflow: %v", err)
+	}
+
+	req.Parties = append(req.Parties, nil)
+	if _, err := req.prepare(o); err != ErrPartyNil {
+		t.Fatalf("nil party: %v", err)
+	}
+
+	// The spare capacity of the caller's Parties is not written.
+	parties := make([]IOrder, 1, 2)
+	parties[0] = party("seller", "10")
+	req = Request{Initiator: party("buyer", "-10"), Parties: parties}
+	if _, err := req.prepare(o); err != nil {
+		t.Fatal(err)
+	}
+	if parties[:2][1] != nil {
+		t.Fatal("parties aliased")
+	}
+}
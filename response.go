@@ -8,6 +8,8 @@ import (
 // The result of dealing respuest.
 type Response struct {
 	Err      error
+	Replayed bool             //whether it is the original response of the repeated idempotency key
+	Events   []Event          //the events emitted in the transaction, publish them after committing the caller's Tx
 	respChan chan<- *Response //result signal
 	done     bool
 	lock     sync.RWMutex
@@ -20,6 +22,29 @@ func (resp *Response) setError(err error) {
 	resp.lock.Unlock()
 }
 
+// Mark the response as replayed
+func (resp *Response) setReplayed() {
+	resp.lock.Lock()
+	resp.Replayed = true
+	resp.lock.Unlock()
+}
+
+// Append the event
+func (resp *Response) emit(event Event) {
+	resp.lock.Lock()
+	resp.Events = append(resp.Events, event)
+	resp.lock.Unlock()
+}
+
+// Take the events, and clear them
+func (resp *Response) takeEvents() []Event {
+	resp.lock.Lock()
+	defer resp.lock.Unlock()
+	events := resp.Events
+	resp.Events = nil
+	return events
+}
+
 // Complete the dealing of the respuest.
 func (resp *Response) writeback() {
 	resp.lock.Lock()
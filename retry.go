@@ -0,0 +1,154 @@
+package opay
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// RetryPolicy retries the requests failed with the transient errors,
+// such as the deadlocks and the serialization failures.
+// Only the requests in the transactions owned by opay are retried,
+// each time with a fresh transaction and a fresh handler instance.
+// The failures of committing are retried only if they are serialization failures or deadlocks.
+type RetryPolicy struct {
+	MaxRetries int              //the max number of the retries
+	Backoff    time.Duration    //the delay before the first retry, doubled on each retry
+	MaxBackoff time.Duration    //the max delay, no limit if 0
+	Transient  func(error) bool //classifies the errors, IsTransient if nil
+}
+
+const (
+	DEFAULT_RETRY_BACKOFF     = 10 * time.Millisecond // DEFAULT_RETRY_BACKOFF is the default delay before the first retry
+	DEFAULT_RETRY_MAX_BACKOFF = time.Second           // DEFAULT_RETRY_MAX_BACKOFF is the default max delay of the retries
+)
+
+// The SQLSTATE codes and the messages of the serialization failures and the deadlocks of the drivers,
+// which definitely roll back the transaction.
+var conflictMessages = []string{
+	"SQLSTATE 40001",             //serialization failure of pgx
+	"SQLSTATE 40P01",             //deadlock detected of pgx
+	"Error 1213",                 //deadlock of mysql
+	"deadlock",                   //deadlock of most drivers
+	"could not serialize access", //serialization failure of postgres
+}
+
+// The messages of the other transient errors of the drivers.
+var transientMessages = []string{
+	"Error 1205",               //lock wait timeout of mysql
+	"database is locked",       //busy of sqlite
+	"database table is locked", //locked of sqlite
+}
+
+// NewRetryPolicy creates a policy retrying at most maxRetries times with the default backoff.
+func NewRetryPolicy(maxRetries int) *RetryPolicy {
+	return &RetryPolicy{
+		MaxRetries: maxRetries,
+		Backoff:    DEFAULT_RETRY_BACKOFF,
+		MaxBackoff: DEFAULT_RETRY_MAX_BACKOFF,
+	}
+}
+
+// IsTransient reports whether err is a transient database error, which may succeed if retried.
+// The errors with the methods 'Transient() bool' or 'SQLState() string'
+// are classified by them, the others by the messages.
+// The generic 'Temporary() bool' errors, such as the network timeouts, are not transient,
+// since the writes may have been applied.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var transient interface{ Transient() bool }
+	if errors.As(err, &transient) {
+		return transient.Transient()
+	}
+	var state interface{ SQLState() string }
+	if errors.As(err, &state) {
+		return isConflict(err)
+	}
+	return isConflict(err) || containsAny(err, transientMessages)
+}
+
+// Reports whether err is a serialization failure or a deadlock,
+// the only failures of committing which are retried.
+func isConflict(err error) bool {
+	var state interface{ SQLState() string }
+	if errors.As(err, &state) {
+		code := state.SQLState()
+		return code == "40001" || code == "40P01"
+	}
+	return containsAny(err, conflictMessages)
+}
+
+// Reports whether the message of err contains any of the strings, ignoring case.
+func containsAny(err error, strs []string) bool {
+	msg := strings.ToLower(err.Error())
+	for _, s := range strs {
+		if strings.Contains(msg, strings.ToLower(s)) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetRetryPolicy sets the policy retrying the requests failed with the transient errors,
+// no retry if nil. It must be called before starting.
+func (opay *Opay) SetRetryPolicy(policy *RetryPolicy) error {
+	opay.stateMu.Lock()
+	defer opay.stateMu.Unlock()
+	if opay.started {
+		return ErrStarted
+	}
+	opay.retry = policy
+	return nil
+}
+
+// Reports whether the error should be retried,
+// the failure of committing is retried only if it is a serialization failure or a deadlock,
+// otherwise the transaction may have been committed.
+func (p *RetryPolicy) retryable(err error, committing bool) bool {
+	if committing && !isConflict(err) {
+		return false
+	}
+	if p.Transient != nil {
+		return p.Transient(err)
+	}
+	return IsTransient(err)
+}
+
+// Returns the delay before the nth retry, which starts from 1.
+func (p *RetryPolicy) delay(n int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < n && d > 0; i++ {
+		d *= 2
+		if p.MaxBackoff > 0 && d >= p.MaxBackoff {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
+
+// Handles the request, and retries it by the policy if the transaction is owned by opay.
+func (opay *Opay) handleRetry(req Request, initiatorSettle, stakeholderSettle SettleFunc, partySettles []SettleFunc) (err error) {
+	committing, err := opay.handle(req, initiatorSettle, stakeholderSettle, partySettles)
+	policy := opay.retry
+	if policy == nil || req.Tx != nil {
+		return
+	}
+	for n := 1; err != nil && n <= policy.MaxRetries && policy.retryable(err, committing); n++ {
+		timer := time.NewTimer(policy.delay(n))
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		opay.metrics.retried()
+		committing, err = opay.handle(req, initiatorSettle, stakeholderSettle, partySettles)
+	}
+	return
+}
@@ -0,0 +1,143 @@
+package opay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type transientError bool
+
+func (e transientError) Error() string   { return "transient" }
+func (e transientError) Transient() bool { return bool(e) }
+
+// Such as the network timeouts.
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "i/o timeout" }
+func (temporaryError) Temporary() bool { return true }
+
+type stateError string
+
+func (e stateError) Error() string    { return "sql state " + string(e) }
+func (e stateError) SQLState() string { return string(e) }
+
+func TestIsTransient(t *testing.T) {
+	for _, c := range []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("Error 1213 (40001): Deadlock found when trying to get lock; try restarting transaction"), true},
+		{errors.New("Error 1205: Lock wait timeout exceeded; try restarting transaction"), true},
+		{errors.New("pq: could not serialize access due to concurrent update"), true},
+		{errors.New("ERROR: deadlock detected (SQLSTATE 40P01)"), true},
+		{fmt.Errorf("settle: %w", errors.New("database is locked")), true},
+		{errors.New("Error 1062: Duplicate entry"), false},
+		{stateError("40001"), true},
+		{stateError("23505"), false},
+		{transientError(true), true},
+		{transientError(false), false},
+		{ErrPanic.Wrap(transientError(true)), true},
+		{temporaryError{}, false},
+		{ErrInvalidStep, false},
+		{context.DeadlineExceeded, false},
+	} {
+		if got := IsTransient(c.err); got != c.want {
+			t.Errorf("IsTransient(%v) = %v, want %v", c.err, got, c.want)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	p := NewRetryPolicy(5)
+	p.Backoff, p.MaxBackoff = 10*time.Millisecond, 50*time.Millisecond
+	for n, want := range []time.Duration{10, 20, 40, 50, 50} {
+		if got := p.delay(n + 1); got != want*time.Millisecond {
+			t.Errorf("delay(%d) = %s, want %s", n+1, got, want*time.Millisecond)
+		}
+	}
+	p.Transient = func(err error) bool { return err == ErrReprocess }
+	if !p.retryable(ErrReprocess, false) || p.retryable(transientError(true), false) {
+		t.Fatal("custom classifier is not used")
+	}
+	if p.retryable(ErrReprocess, true) {
+		t.Fatal("failure of committing is retried")
+	}
+}
+
+// Returns an opay retrying the failed requests of the meta "test" handled by fn.
+func newRetryOpay(t *testing.T, fn func(ctx *Context) error) (*Opay, *Meta) {
+	db := newTestDB(t)
+	o := New(db, WithSettleFuncMap(newTestSettles()))
+	policy := NewRetryPolicy(3)
+	policy.Backoff = time.Millisecond
+	if err := o.SetRetryPolicy(policy); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := o.RegMeta("test", HandlerFunc(fn), []Status{
+		{Code: 1, Note: "pend", Step: PEND},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	startTestOpay(t, o)
+	return o, meta
+}
+
+func TestRetry(t *testing.T) {
+	var txs []*sqlx.Tx
+	o, meta := newRetryOpay(t, func(ctx *Context) error {
+		txs = append(txs, ctx.Request.Tx)
+		if len(txs) == 1 {
+			return errors.New("ERROR: deadlock detected (SQLSTATE 40P01)")
+		}
+		return nil
+	})
+	if err := o.Do(newTestRequest(meta, "u1", 1)).Err; err != nil {
+		t.Fatal(err)
+	}
+	if len(txs) != 2 || txs[0] == txs[1] || o.Stats().Retries != 1 {
+		t.Fatalf("handled %d times, retries: %d", len(txs), o.Stats().Retries)
+	}
+
+	// The caller's Tx is not retried.
+	txs = nil
+	tx, err := o.DB().Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	req := newTestRequest(meta, "u1", 1)
+	req.Tx = tx
+	if err = o.Do(req).Err; err == nil || len(txs) != 1 {
+		t.Fatalf("caller's Tx: handled %d times, %v", len(txs), err)
+	}
+}
+
+func TestRetryCommit(t *testing.T) {
+	var n int
+	o, meta := newRetryOpay(t, func(ctx *Context) error {
+		n++
+		// Violates the deferred foreign key, which fails on committing.
+		_, err := ctx.Request.Tx.Exec("INSERT INTO child (parent_id) VALUES (1)")
+		return err
+	})
+	for _, stmt := range []string{
+		"PRAGMA foreign_keys = ON",
+		"CREATE TABLE parent (id INTEGER PRIMARY KEY)",
+		"CREATE TABLE child (parent_id INTEGER REFERENCES parent (id) DEFERRABLE INITIALLY DEFERRED)",
+	} {
+		if _, err := o.DB().Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	o.retry.Transient = func(error) bool { return true }
+	if err := o.Do(newTestRequest(meta, "u1", 1)).Err; err == nil || n != 1 {
+		t.Fatalf("handled %d times, %v", n, err)
+	}
+}
@@ -0,0 +1,43 @@
+package opay
+
+import (
+	"strconv"
+	"sync/atomic"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// A savepoint in the caller's transaction,
+// so that the failed request does not leave partial writes in it.
+type savepoint struct {
+	tx   *sqlx.Tx
+	name string
+}
+
+// Creates a savepoint with a unique name in tx.
+func (opay *Opay) savepoint(tx *sqlx.Tx) (*savepoint, error) {
+	sp := &savepoint{
+		tx:   tx,
+		name: "opay_" + strconv.FormatUint(atomic.AddUint64(&opay.savepoints, 1), 10),
+	}
+	if _, err := tx.Exec("SAVEPOINT " + sp.name); err != nil {
+		return nil, err
+	}
+	return sp, nil
+}
+
+// Discards the writes after the savepoint, and removes it,
+// so that the savepoints do not pile up in a long transaction.
+func (sp *savepoint) rollback() error {
+	_, err := sp.tx.Exec("ROLLBACK TO SAVEPOINT " + sp.name)
+	if err != nil {
+		return err
+	}
+	return sp.release()
+}
+
+// Keeps the writes after the savepoint, and removes it.
+func (sp *savepoint) release() error {
+	_, err := sp.tx.Exec("RELEASE SAVEPOINT " + sp.name)
+	return err
+}
@@ -0,0 +1,70 @@
+package opay
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func TestSavepoint(t *testing.T) {
+	db := newTestDB(t)
+	if _, err := db.Exec("CREATE TABLE written (uid VARCHAR(64) NOT NULL)"); err != nil {
+		t.Fatal(err)
+	}
+	o := New(db, WithSettleFuncMap(newTestSettles()))
+	meta, err := o.RegMeta("test", HandlerFunc(func(ctx *Context) error {
+		uid := ctx.Initiator.GetUid()
+		if _, err := ctx.Request.Tx.Exec("INSERT INTO written (uid) VALUES (?)", uid); err != nil {
+			return err
+		}
+		switch uid {
+		case "fail":
+			return ErrIncorrectAmount
+		case "panic":
+			panic("boom")
+		}
+		return nil
+	}), []Status{
+		{Code: 1, Note: "pend", Step: PEND},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	startTestOpay(t, o)
+
+	tx, err := db.Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	for _, c := range []struct {
+		uid string
+		err error
+	}{
+		{"a", nil},
+		{"fail", ErrIncorrectAmount},
+		{"panic", ErrPanic},
+		{"b", nil},
+	} {
+		req := newTestRequest(meta, c.uid, 1)
+		req.Tx = tx
+		if err = o.Do(req).Err; !errors.Is(err, c.err) || (c.err == nil) != (err == nil) {
+			t.Fatalf("%s: %v", c.uid, err)
+		}
+		// The savepoint is removed either way.
+		if _, err = tx.Exec("RELEASE SAVEPOINT opay_" + strconv.FormatUint(o.savepoints, 10)); err == nil {
+			t.Fatalf("%s: savepoint is not removed", c.uid)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	var written []string
+	if err = db.Select(&written, "SELECT uid FROM written"); err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != 2 || written[0] != "a" || written[1] != "b" {
+		t.Fatalf("written: %v", written)
+	}
+}
@@ -0,0 +1,53 @@
+package opay
+
+import (
+	"sync"
+)
+
+// accountSequencer serializes the requests touching the same accounts,
+// in the order they enter, while the others run in parallel.
+// A request only waits for the ones entered before it, so there is no deadlock.
+type accountSequencer struct {
+	mu    sync.Mutex
+	tails map[string]chan struct{} //done signal of the last request of each account
+}
+
+func newAccountSequencer() *accountSequencer {
+	return &accountSequencer{
+		tails: make(map[string]chan struct{}),
+	}
+}
+
+// Enters the accounts, returns the function waiting for the previous requests,
+// and the one to call after processing.
+func (s *accountSequencer) enter(accounts []string) (wait func(), leave func()) {
+	var (
+		done  = make(chan struct{})
+		prevs = make([]chan struct{}, 0, len(accounts))
+	)
+	s.mu.Lock()
+	for _, account := range accounts {
+		if prev, ok := s.tails[account]; ok {
+			prevs = append(prevs, prev)
+		}
+		s.tails[account] = done
+	}
+	s.mu.Unlock()
+
+	wait = func() {
+		for _, prev := range prevs {
+			<-prev
+		}
+	}
+	leave = func() {
+		s.mu.Lock()
+		for _, account := range accounts {
+			if s.tails[account] == done {
+				delete(s.tails, account)
+			}
+		}
+		s.mu.Unlock()
+		close(done)
+	}
+	return
+}
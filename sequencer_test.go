@@ -0,0 +1,46 @@
+package opay
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAccountSequencer(t *testing.T) {
+	var (
+		s     = newAccountSequencer()
+		mu    sync.Mutex
+		order []int
+		wg    sync.WaitGroup
+	)
+	for i := 0; i < 5; i++ {
+		wait, leave := s.enter([]string{"1\x00a", "1\x00b"})
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer leave()
+			// The later ones start first.
+			time.Sleep(time.Duration(5-i) * time.Millisecond)
+			wait()
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+		}(i)
+	}
+	wg.Wait()
+	for i, v := range order {
+		if v != i {
+			t.Fatalf("order: %v", order)
+		}
+	}
+	if len(s.tails) != 0 {
+		t.Fatalf("tails are not cleaned: %d", len(s.tails))
+	}
+
+	// The other accounts do not wait.
+	_, leave := s.enter([]string{"1\x00a"})
+	wait, leave2 := s.enter([]string{"1\x00c"})
+	wait()
+	leave2()
+	leave()
+}
@@ -1,19 +1,30 @@
 package opay
 
 import (
-	"errors"
 	"sync"
 
 	"github.com/jmoiron/sqlx"
 )
 
 // SettleFunc: Account balance operation function.
-type SettleFunc func(uid string, amount float64, tx *sqlx.Tx) error
+type SettleFunc func(uid string, amount Amount, tx *sqlx.Tx) error
+
+// Freezer operates the frozen balance of the accounts of an asset,
+// the amounts are positive.
+type Freezer interface {
+	// Freeze moves the amount from the available balance to the frozen balance.
+	Freeze(uid string, amount Amount, tx *sqlx.Tx) error
+	// Unfreeze moves the amount from the frozen balance back to the available balance.
+	Unfreeze(uid string, amount Amount, tx *sqlx.Tx) error
+	// Capture debits the amount from the frozen balance.
+	Capture(uid string, amount Amount, tx *sqlx.Tx) error
+}
 
 // SettleFuncMap: Account Balance Operations Function Router.
 type SettleFuncMap struct {
-	mu sync.RWMutex
-	m  map[string]SettleFunc
+	mu       sync.RWMutex
+	m        map[string]SettleFunc
+	freezers map[string]Freezer
 }
 
 // GetSettleFunc gets the account balance operation function
@@ -23,7 +34,7 @@ func (this *SettleFuncMap) GetSettleFunc(aid string) (SettleFunc, error) {
 	acc, ok := this.m[aid]
 	this.mu.RUnlock()
 	if !ok {
-		return nil, errors.New("opay: not found SettleFunc '" + aid + "'.")
+		return nil, ErrSettleNotFound.With("opay.settle_not_found.detail", aid)
 	}
 	return acc, nil
 }
@@ -35,17 +46,51 @@ func (this *SettleFuncMap) RegSettleFunc(aid string, fn SettleFunc) error {
 	defer this.mu.Unlock()
 	_, ok := this.m[aid]
 	if ok {
-		return errors.New("opay: settleFunc '" + aid + "' has been registered.")
+		return ErrSettleRegistered.With("opay.settle_registered.detail", aid)
 	}
 	this.m[aid] = fn
 	return nil
 }
 
+// GetFreezer gets the frozen balance operation interface.
+// @aid Assets ID
+func (this *SettleFuncMap) GetFreezer(aid string) (Freezer, error) {
+	this.mu.RLock()
+	f, ok := this.freezers[aid]
+	this.mu.RUnlock()
+	if !ok {
+		return nil, ErrFreezerNotFound.With("opay.freezer_not_found.detail", aid)
+	}
+	return f, nil
+}
+
+// RegFreezer registers the frozen balance operation interface.
+// @aid Assets ID
+func (this *SettleFuncMap) RegFreezer(aid string, f Freezer) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	_, ok := this.freezers[aid]
+	if ok {
+		return ErrFreezerRegistered.With("opay.freezer_registered.detail", aid)
+	}
+	if this.freezers == nil {
+		this.freezers = make(map[string]Freezer)
+	}
+	this.freezers[aid] = f
+	return nil
+}
+
 // Global account operation interface list, the default registered empty asset account empty operation interface.
-var globalSettleFuncMap = &SettleFuncMap{
-	m: map[string]SettleFunc{
-		"": emptySettle,
-	},
+var globalSettleFuncMap = NewSettleFuncMap()
+
+// NewSettleFuncMap creates a SettleFuncMap with the empty operation function of the empty asset,
+// see WithSettleFuncMap.
+func NewSettleFuncMap() *SettleFuncMap {
+	return &SettleFuncMap{
+		m: map[string]SettleFunc{
+			"": emptySettle,
+		},
+	}
 }
 
 // RegSettleFunc registers the account balance operation function.
@@ -54,7 +99,13 @@ func RegSettleFunc(aid string, acc SettleFunc) error {
 	return globalSettleFuncMap.RegSettleFunc(aid, acc)
 }
 
+// RegFreezer registers the frozen balance operation interface.
+// @aid Assets ID
+func RegFreezer(aid string, f Freezer) error {
+	return globalSettleFuncMap.RegFreezer(aid, f)
+}
+
 // Empty Settle Function of empty asset.
-func emptySettle(uid string, amount float64, tx *sqlx.Tx) error {
-	return errors.New("opay: empty settle function.")
+func emptySettle(uid string, amount Amount, tx *sqlx.Tx) error {
+	return ErrEmptySettle
 }
@@ -1,5 +1,9 @@
 package opay
 
+import (
+	"strconv"
+)
+
 type (
 	// handling order's action
 	Step int
@@ -26,4 +30,21 @@ var (
 		SUCCEED:   true,
 		SYNC_DEAL: true,
 	}
+	stepNames = map[Step]string{
+		FAIL:      "FAIL",
+		CANCEL:    "CANCEL",
+		UNSET:     "UNSET",
+		PEND:      "PEND",
+		DO:        "DO",
+		SUCCEED:   "SUCCEED",
+		SYNC_DEAL: "SYNC_DEAL",
+	}
 )
+
+// String returns the name of the step.
+func (s Step) String() string {
+	if name, ok := stepNames[s]; ok {
+		return name
+	}
+	return "Step(" + strconv.Itoa(int(s)) + ")"
+}
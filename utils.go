@@ -24,6 +24,8 @@ func checkTimeout(deadline time.Time) (timeout time.Duration, errTimeout error)
 	return
 }
 
+// Floater formats and compares float64 numbers with fixed decimal places,
+// it is kept for the legacy float64 amounts.
 type Floater struct {
 	numOfDecimalPlaces int
 	accuracy           float64
@@ -104,6 +106,11 @@ func (this *Floater) SmallerOrEqual(a, b float64) bool {
 	return math.Min(a, b) == a || this.IsZero(b-a)
 }
 
+// Amount converts f to Amount with the number of decimal places.
+func (this *Floater) Amount(f float64) (Amount, error) {
+	return AmountFromFloat(f, this.numOfDecimalPlaces)
+}
+
 func (this *Floater) IsZero(a float64) bool {
 	return this.Ftoa(math.Abs(a)) == this.zeroString
 }